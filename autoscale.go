@@ -0,0 +1,250 @@
+package pipeline
+
+import (
+	"context"
+	"log/slog"
+	"runtime"
+	"runtime/pprof"
+	"strconv"
+	"sync/atomic"
+	"time"
+)
+
+// Autoscale — параметры автоматического масштабирования пула воркеров.
+//
+// Каждые Interval контроллер смотрит, насколько заполнен буфер общего
+// канала. Если заполнено не меньше доли ScaleUp, он запускает ещё один
+// воркер (но не больше Max), если не больше доли ScaleDown — останавливает
+// один (но оставляет не меньше Min). Нулевые поля заменяются значениями
+// по умолчанию: Min 1, Max 4·Min, Interval 10 мс, ScaleUp 0.75,
+// ScaleDown 0.25.
+type Autoscale struct {
+	Min       int
+	Max       int
+	Interval  time.Duration
+	ScaleUp   float64
+	ScaleDown float64
+}
+
+// ScaleEvent — изменение количества воркеров при масштабировании.
+type ScaleEvent struct {
+	At      time.Duration // время от начала запуска
+	Workers int           // количество воркеров после изменения
+}
+
+// WithAutoscale заменяет фиксированный пул воркеров на пул, размер
+// которого подстраивается под заполненность общего канала, см. Autoscale.
+// Запуск начинается с a.Min воркеров; изменения записываются в
+// Stats.ScaleEvents, а Stats.PerChannel содержит a.Max элементов — по
+// одному на каждое место в пуле. Если буфер общего канала не задан, он
+// становится равным a.Max.
+func WithAutoscale(a Autoscale) Option {
+	return func(p *Pipeline) {
+		a.Min = max(a.Min, 1)
+		if a.Max < a.Min {
+			a.Max = 4 * a.Min
+		}
+		if a.Interval <= 0 {
+			a.Interval = 10 * time.Millisecond
+		}
+		if a.ScaleUp <= 0 {
+			a.ScaleUp = 0.75
+		}
+		if a.ScaleDown <= 0 {
+			a.ScaleDown = 0.25
+		}
+		p.autoscale = &a
+		p.autoWorkers = false
+	}
+}
+
+// WithAutoWorkers подбирает количество воркеров по пропускной
+// способности, как AutoWorkers: запуск начинается с одного воркера, и
+// каждые 20 мс добавляется ещё один, пока это увеличивает количество
+// чисел в секунду на выходе пула хотя бы на 5%. Как только очередной
+// воркер прироста не дал, он убирается, и размер больше не меняется; он
+// не превышает 4·GOMAXPROCS. Выбранный размер записывается в
+// Stats.AutoWorkers, а изменения — в Stats.ScaleEvents. Пул строится так
+// же, как у WithAutoscale, и заменяет эту опцию.
+func WithAutoWorkers() Option {
+	return func(p *Pipeline) {
+		WithAutoscale(Autoscale{Min: 1, Max: 4 * runtime.GOMAXPROCS(0), Interval: autoWorkersInterval})(p)
+		p.autoWorkers = true
+	}
+}
+
+// scaleSlot — место в пуле. Пока место занято, в нём работает Worker;
+// после остановки воркера место ждёт, пока его снова займут.
+type scaleSlot struct {
+	activate chan scaleRun // следующий воркер места
+	cancel   context.CancelFunc
+}
+
+// scaleRun — контекст воркера, занимающего место, и его отмена.
+type scaleRun struct {
+	ctx    context.Context
+	cancel context.CancelFunc
+}
+
+// autoscaleOuts запускает масштабируемый пул из a.Max мест, читающих
+// общий канал in воркерами с опциями opts(i), где i — номер места, и
+// контроллер, управляющий количеством занятых мест.
+// Места завершаются, когда закрыт genDone (генератор закончил работу)
+// или отменён ctx; занятые места перед этим дочитывают in.
+func (p *Pipeline) autoscaleOuts(ctx context.Context, in chan int64, genDone <-chan struct{}, opts func(id int) []WorkerOption, s *Stats, logger *slog.Logger) ([]chan int64, []*WorkerStat) {
+	a := p.autoscale
+	outs := make([]chan int64, a.Max)
+	slots := make([]*scaleSlot, a.Max)
+	var stats []*WorkerStat
+	if p.workerStats {
+		stats = make([]*WorkerStat, a.Max)
+	}
+	// processed — сколько чисел пул выдал с последнего измерения WithAutoWorkers
+	var processed atomic.Int64
+
+	for i := range slots {
+		slot := &scaleSlot{activate: make(chan scaleRun, 1)}
+		slots[i] = slot
+		out := make(chan int64)
+		outs[i] = out
+
+		opts := opts(i)
+		if p.workerStats {
+			stats[i] = &WorkerStat{}
+			opts = append(opts[:len(opts):len(opts)], WithStat(stats[i]))
+		}
+		id := i
+		events := p.runEvents
+		p.goLabeled(pprof.Labels("stage", "worker", "id", strconv.Itoa(id)), func() {
+			defer close(out)
+			for {
+				var run scaleRun
+				select {
+				case <-ctx.Done():
+					return
+				case <-genDone:
+					// место, занятое перед самым концом, всё равно
+					// помогает дочитать буфер общего канала
+					select {
+					case run = <-slot.activate:
+					default:
+						return
+					}
+				case run = <-slot.activate:
+				}
+				wctx := run.ctx
+
+				logger.Debug("worker started", slog.Int("worker", id))
+				events.send(WorkerStarted{ID: id})
+				tmp := make(chan int64)
+				p.goStage(func() {
+					Worker(wctx, in, tmp, opts...)
+				})
+				for v := range tmp {
+					out <- v
+					if p.autoWorkers {
+						processed.Add(1)
+					}
+				}
+				logger.Debug("worker stopped", slog.Int("worker", id))
+				events.send(WorkerStopped{ID: id})
+
+				retired := wctx.Err() != nil && ctx.Err() == nil
+				run.cancel()
+				if !retired {
+					// воркер дочитал закрытый канал или отменён весь пул
+					return
+				}
+			}
+		})
+	}
+
+	// activate занимает место i; контекст воркера создаётся здесь же,
+	// чтобы его можно было отменить, даже если воркер ещё не запустился
+	activate := func(i int) bool {
+		wctx, cancel := context.WithCancel(ctx)
+		slots[i].cancel = cancel
+		select {
+		case slots[i].activate <- scaleRun{wctx, cancel}:
+			return true
+		case <-ctx.Done():
+		case <-genDone:
+		}
+		cancel()
+		return false
+	}
+
+	active := a.Min
+	for i := range active {
+		activate(i)
+	}
+
+	p.goStage(func() {
+		start := p.clock.Now()
+		t := p.clock.NewTicker(a.Interval)
+		defer t.Stop()
+
+		var tuner *workerTuner
+		if p.autoWorkers {
+			tuner = new(workerTuner)
+			// подбор мог не закончиться до конца запуска, тогда
+			// выбранным считается размер на этот момент
+			defer func() { s.AutoWorkers = active }()
+		}
+		last := start
+		for {
+			var now time.Time
+			select {
+			case <-ctx.Done():
+				return
+			case <-genDone:
+				return
+			case now = <-t.C():
+			}
+
+			var grow, settled bool
+			var attr slog.Attr
+			if tuner != nil {
+				rate := float64(processed.Swap(0)) / now.Sub(last).Seconds()
+				last = now
+				attr = slog.Float64("rate", rate)
+				switch tuner.next(rate, active, a.Max) {
+				case tuneWait:
+					continue
+				case tuneGrow:
+					grow = true
+				case tuneShrink:
+					settled = true
+				case tuneDone:
+					return
+				}
+			} else {
+				fill := float64(len(in)) / float64(cap(in))
+				attr = slog.Float64("fill", fill)
+				switch {
+				case fill >= a.ScaleUp && active < a.Max:
+					grow = true
+				case fill <= a.ScaleDown && active > a.Min:
+				default:
+					continue
+				}
+			}
+			if grow {
+				if !activate(active) {
+					return
+				}
+				active++
+			} else {
+				active--
+				slots[active].cancel()
+			}
+			s.ScaleEvents = append(s.ScaleEvents, ScaleEvent{At: p.clock.Now().Sub(start), Workers: active})
+			logger.Debug("pool scaled", slog.Int("workers", active), attr)
+			if settled {
+				return
+			}
+		}
+	})
+
+	return outs, stats
+}
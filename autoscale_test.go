@@ -0,0 +1,48 @@
+package pipeline
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+// burstSource выдаёт burst чисел сразу, а потом ещё quiet чисел с паузой
+// gap перед каждым.
+type burstSource struct {
+	n, burst, quiet int64
+	gap             time.Duration
+}
+
+func (s *burstSource) Next() (int64, bool) {
+	if s.n >= s.burst+s.quiet {
+		return 0, false
+	}
+	if s.n >= s.burst {
+		time.Sleep(s.gap)
+	}
+	s.n++
+	return s.n, true
+}
+
+func TestAutoscaleBurst(t *testing.T) {
+	src := &burstSource{burst: 400, quiet: 20, gap: 5 * time.Millisecond}
+	p := NewPipeline(1, WithInputSource(src), WithAutoscale(Autoscale{Min: 1, Max: 4, Interval: 5 * time.Millisecond}))
+	s := p.Run(context.Background())
+	if err := CheckInvariants(s); err != nil {
+		t.Fatal(err)
+	}
+
+	peak, peakAt := 1, -1
+	for i, e := range s.ScaleEvents {
+		if e.Workers > peak {
+			peak, peakAt = e.Workers, i
+		}
+	}
+	if peak < 2 {
+		t.Fatalf("ScaleEvents = %v: воркеров не прибавилось во время всплеска", s.ScaleEvents)
+	}
+	last := s.ScaleEvents[len(s.ScaleEvents)-1]
+	if peakAt == len(s.ScaleEvents)-1 || last.Workers >= peak {
+		t.Fatalf("ScaleEvents = %v: воркеров не убавилось после всплеска", s.ScaleEvents)
+	}
+}
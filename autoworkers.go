@@ -0,0 +1,158 @@
+package pipeline
+
+import (
+	"context"
+	"runtime"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// autoWorkersInterval — за сколько времени AutoWorkers измеряет
+// пропускную способность пула одного размера.
+const autoWorkersInterval = 20 * time.Millisecond
+
+// autoWorkersGain — насколько должна вырасти пропускная способность,
+// чтобы AutoWorkers счёл добавленный воркер полезным.
+const autoWorkersGain = 1.05
+
+// AutoWorkers обрабатывает числа из in функцией process в пуле, размер
+// которого подбирается сам, и отправляет результаты в out в порядке
+// готовности. Пул начинает с одного воркера и каждые 20 мс добавляет ещё
+// один, пока это увеличивает количество обработанных чисел в секунду
+// хотя бы на 5%. Как только очередной воркер прироста не дал, он
+// убирается, и размер больше не меняется. Размер не превышает
+// 4·GOMAXPROCS. workers возвращает текущий размер пула, а после
+// остановки подбора — выбранный.
+//
+// Подбор имеет смысл, когда in успевает за пулом: если числа приходят
+// медленнее, чем их обрабатывает один воркер, прироста не будет, и пул
+// останется из одного воркера. out закрывается после закрытия in или
+// отмены ctx; прочитанное число передаётся в out в любом случае.
+func AutoWorkers(ctx context.Context, in <-chan int64, process func(int64) int64) (out <-chan int64, workers func() int) {
+	return autoWorkers(ctx, in, process, RealClock)
+}
+
+// autoWorkers работает как AutoWorkers, но измеряет пропускную
+// способность по часам clock.
+func autoWorkers(ctx context.Context, in <-chan int64, process func(int64) int64, clock Clock) (out <-chan int64, workers func() int) {
+	res := make(chan int64)
+	var size atomic.Int64
+	var processed atomic.Int64
+	var wg sync.WaitGroup
+
+	// inClosed закрывается воркером, первым увидевшим закрытие in
+	inClosed := make(chan struct{})
+	var closeOnce sync.Once
+
+	var stops []chan struct{}
+	add := func() {
+		stop := make(chan struct{})
+		stops = append(stops, stop)
+		size.Add(1)
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for {
+				var v int64
+				select {
+				case <-ctx.Done():
+					return
+				case <-stop:
+					return
+				case x, ok := <-in:
+					if !ok {
+						closeOnce.Do(func() { close(inClosed) })
+						return
+					}
+					v = x
+				}
+				res <- process(v)
+				processed.Add(1)
+			}
+		}()
+	}
+	remove := func() {
+		last := len(stops) - 1
+		close(stops[last])
+		stops = stops[:last]
+		size.Add(-1)
+	}
+
+	limit := 4 * runtime.GOMAXPROCS(0)
+	add()
+
+	go func() {
+		// контроллер сам запускает воркеры, поэтому wg.Wait ждёт его выхода
+		defer func() {
+			wg.Wait()
+			close(res)
+		}()
+
+		ticker := clock.NewTicker(autoWorkersInterval)
+		defer ticker.Stop()
+
+		var tuner workerTuner
+		last := clock.Now()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-inClosed:
+				return
+			case now := <-ticker.C():
+				rate := float64(processed.Swap(0)) / now.Sub(last).Seconds()
+				last = now
+				switch tuner.next(rate, len(stops), limit) {
+				case tuneWait:
+				case tuneGrow:
+					add()
+				case tuneShrink:
+					remove()
+					return
+				case tuneDone:
+					return
+				}
+			}
+		}
+	}()
+
+	return res, func() int { return int(size.Load()) }
+}
+
+// workerTuner — правило, по которому AutoWorkers и WithAutoWorkers
+// подбирают размер пула по пропускной способности, измеренной через
+// равные промежутки времени.
+type workerTuner struct {
+	best float64 // пропускная способность при текущем размере
+}
+
+// tuneStep — решение workerTuner после очередного измерения.
+type tuneStep int
+
+const (
+	tuneWait   tuneStep = iota // чисел не было, размер прежний
+	tuneGrow                   // добавить воркер
+	tuneShrink                 // убрать последний добавленный воркер, подбор закончен
+	tuneDone                   // оставить размер как есть, подбор закончен
+)
+
+// next принимает решение для пула из size воркеров с пределом limit по
+// пропускной способности rate за последний промежуток.
+func (t *workerTuner) next(rate float64, size, limit int) tuneStep {
+	switch {
+	case rate == 0:
+		// чисел пока нет, сравнивать нечего
+		return tuneWait
+	case t.best == 0:
+		// первое измерение
+	case rate < t.best*autoWorkersGain:
+		// последний добавленный воркер не помог
+		return tuneShrink
+	}
+	t.best = rate
+	if size >= limit {
+		return tuneDone
+	}
+	return tuneGrow
+}
@@ -0,0 +1,105 @@
+package pipeline
+
+import (
+	"context"
+	"runtime"
+	"testing"
+	"time"
+)
+
+func TestWorkerTuner(t *testing.T) {
+	tests := []struct {
+		name  string
+		rates []float64
+		limit int
+		want  []tuneStep
+	}{
+		{"grows while rate improves", []float64{100, 200, 300}, 8, []tuneStep{tuneGrow, tuneGrow, tuneGrow}},
+		{"waits without values", []float64{0, 100, 0}, 8, []tuneStep{tuneWait, tuneGrow, tuneWait}},
+		{"shrinks without gain", []float64{100, 200, 201}, 8, []tuneStep{tuneGrow, tuneGrow, tuneShrink}},
+		{"stops at limit", []float64{100, 200}, 2, []tuneStep{tuneGrow, tuneDone}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var tuner workerTuner
+			size := 1
+			for i, rate := range tt.rates {
+				step := tuner.next(rate, size, tt.limit)
+				if step != tt.want[i] {
+					t.Fatalf("шаг %d: next(%v, %d) = %d, want %d", i, rate, size, step, tt.want[i])
+				}
+				if step == tuneGrow {
+					size++
+				}
+			}
+		})
+	}
+}
+
+func TestAutoWorkersConverges(t *testing.T) {
+	tests := []struct {
+		name    string
+		process func(int64) int64
+		cpu     bool
+	}{
+		{"cpu-bound", func(v int64) int64 {
+			// около десятков микросекунд чистых вычислений
+			x := uint64(v)
+			for range 20000 {
+				x = x*6364136223846793005 + 1442695040888963407
+			}
+			return int64(x)
+		}, true},
+		{"latency-bound", func(v int64) int64 {
+			time.Sleep(200 * time.Microsecond)
+			return v
+		}, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if tt.cpu && runtime.GOMAXPROCS(0) < 2 {
+				t.Skip("вычислениям на одном процессоре второй воркер не поможет")
+			}
+			ctx, cancel := context.WithTimeout(context.Background(), 400*time.Millisecond)
+			defer cancel()
+
+			in := make(chan int64)
+			go func() {
+				defer close(in)
+				for v := int64(1); ; v++ {
+					select {
+					case <-ctx.Done():
+						return
+					case in <- v:
+					}
+				}
+			}()
+			out, workers := AutoWorkers(ctx, in, tt.process)
+			for range out {
+			}
+			if n := workers(); n < 2 {
+				t.Fatalf("подобрано %d воркеров, want больше одного", n)
+			}
+		})
+	}
+}
+
+func TestWithAutoWorkers(t *testing.T) {
+	// воркер с паузой 1 мс обрабатывает около тысячи чисел в секунду,
+	// поэтому каждый следующий воркер увеличивает пропускную способность
+	p := NewPipeline(1, WithAutoWorkers())
+
+	s, err := p.RunFor(context.Background(), 300*time.Millisecond)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if s.AutoWorkers < 2 {
+		t.Fatalf("AutoWorkers = %d, want больше одного", s.AutoWorkers)
+	}
+	if len(s.ScaleEvents) == 0 || s.ScaleEvents[len(s.ScaleEvents)-1].Workers != s.AutoWorkers {
+		t.Fatalf("ScaleEvents = %v не заканчиваются на %d воркерах", s.ScaleEvents, s.AutoWorkers)
+	}
+	if err := CheckInvariants(s); err != nil {
+		t.Fatal(err)
+	}
+}
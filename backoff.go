@@ -0,0 +1,92 @@
+package pipeline
+
+import (
+	"math"
+	"math/rand"
+	"sync"
+	"time"
+)
+
+// Backoff — стратегия пауз между повторными попытками: Next возвращает
+// паузу перед попыткой номер attempt, начиная с 1. Номер меньше 1
+// считается равным 1. Реализации безопасны для параллельного вызова.
+// Стратегию принимают Breaker.SetBackoff и WithAdaptiveBackoff.
+type Backoff interface {
+	Next(attempt int) time.Duration
+}
+
+// ConstantBackoff — одинаковая пауза перед каждой попыткой.
+type ConstantBackoff time.Duration
+
+// Next возвращает паузу b.
+func (b ConstantBackoff) Next(int) time.Duration {
+	return time.Duration(b)
+}
+
+// LinearBackoff — пауза, растущая на Step с каждой попыткой: Base перед
+// первой, Base+Step перед второй и т.д., но не больше Max (0 — без предела).
+type LinearBackoff struct {
+	Base time.Duration
+	Step time.Duration
+	Max  time.Duration
+}
+
+// Next возвращает Base + Step·(attempt-1), ограниченную Max.
+func (b LinearBackoff) Next(attempt int) time.Duration {
+	d := b.Base + b.Step*time.Duration(max(attempt, 1)-1)
+	if b.Max > 0 {
+		d = min(d, b.Max)
+	}
+	return d
+}
+
+// ExponentialBackoff — пауза, удваивающаяся с каждой попыткой, со
+// случайным разбросом, чтобы повторы многих клиентов не совпадали во
+// времени, см. NewExponentialBackoff.
+type ExponentialBackoff struct {
+	base  time.Duration
+	limit time.Duration
+
+	mu  sync.Mutex
+	rnd *rand.Rand
+}
+
+// NewExponentialBackoff создаёт стратегию, у которой перед попыткой
+// attempt верхняя граница паузы d равна base·2^(attempt-1), но не больше
+// limit, а сама пауза выбирается случайно из [d/2, d]. limit меньше или
+// равный 0 — без предела: граница растёт, пока не упрётся в наибольшую
+// time.Duration. С одинаковым seed паузы повторяются.
+func NewExponentialBackoff(base, limit time.Duration, seed int64) *ExponentialBackoff {
+	return &ExponentialBackoff{
+		base:  base,
+		limit: limit,
+		rnd:   rand.New(rand.NewSource(seed)),
+	}
+}
+
+// Next возвращает случайную паузу из [d/2, d], где d — граница попытки
+// attempt.
+func (b *ExponentialBackoff) Next(attempt int) time.Duration {
+	limit := b.limit
+	if limit <= 0 {
+		limit = math.MaxInt64
+	}
+	d := min(b.base, limit)
+	for i := 1; i < attempt && d > 0 && d < limit; i++ {
+		// сравнение с половиной предела не даёт удвоению переполниться
+		if d > limit/2 {
+			d = limit
+			break
+		}
+		d *= 2
+	}
+	if d <= 0 {
+		return 0
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	half := d / 2
+	return half + time.Duration(b.rnd.Int63n(int64(d-half)+1))
+}
@@ -0,0 +1,100 @@
+package pipeline
+
+import (
+	"math"
+	"testing"
+	"time"
+)
+
+func TestConstantBackoff(t *testing.T) {
+	b := ConstantBackoff(5 * time.Millisecond)
+	for _, attempt := range []int{0, 1, 2, 100} {
+		if got := b.Next(attempt); got != 5*time.Millisecond {
+			t.Fatalf("Next(%d) = %v, want 5ms", attempt, got)
+		}
+	}
+}
+
+func TestLinearBackoff(t *testing.T) {
+	tests := []struct {
+		b       LinearBackoff
+		attempt int
+		want    time.Duration
+	}{
+		{LinearBackoff{Base: 1, Step: 2}, 0, 1},
+		{LinearBackoff{Base: 1, Step: 2}, 1, 1},
+		{LinearBackoff{Base: 1, Step: 2}, 3, 5},
+		{LinearBackoff{Base: 1, Step: 2, Max: 6}, 3, 5},
+		{LinearBackoff{Base: 1, Step: 2, Max: 6}, 9, 6},
+		{LinearBackoff{Base: 1, Step: 2}, 9, 17},
+	}
+	for _, tt := range tests {
+		if got := tt.b.Next(tt.attempt); got != tt.want {
+			t.Errorf("%+v.Next(%d) = %v, want %v", tt.b, tt.attempt, got, tt.want)
+		}
+	}
+}
+
+func TestExponentialBackoffBounds(t *testing.T) {
+	const base = 10 * time.Millisecond
+	tests := []struct {
+		name  string
+		limit time.Duration
+	}{
+		{"limited", time.Second},
+		{"unlimited", 0},
+		{"negative limit", -time.Second},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			b := NewExponentialBackoff(base, tt.limit, 1)
+			limit := tt.limit
+			if limit <= 0 {
+				limit = math.MaxInt64
+			}
+			for attempt := 0; attempt < 100; attempt++ {
+				// граница попытки — base·2^(attempt-1), но не больше предела
+				hi := base
+				for i := 1; i < attempt && hi < limit; i++ {
+					if hi > limit/2 {
+						hi = limit
+						break
+					}
+					hi *= 2
+				}
+				got := b.Next(attempt)
+				if got < hi/2 || got > hi {
+					t.Fatalf("Next(%d) = %v, want в [%v, %v]", attempt, got, hi/2, hi)
+				}
+			}
+		})
+	}
+}
+
+func TestExponentialBackoffUnlimitedGrows(t *testing.T) {
+	b := NewExponentialBackoff(time.Millisecond, 0, 1)
+	if got := b.Next(12); got < 1024*time.Millisecond {
+		t.Fatalf("Next(12) = %v без предела, want не меньше 1.024s", got)
+	}
+	// граница упирается в наибольшую длительность и не переполняется
+	if got := b.Next(1000); got < math.MaxInt64/2 {
+		t.Fatalf("Next(1000) = %v, want не меньше половины наибольшей длительности", got)
+	}
+}
+
+func TestExponentialBackoffSeed(t *testing.T) {
+	a := NewExponentialBackoff(time.Millisecond, time.Second, 7)
+	b := NewExponentialBackoff(time.Millisecond, time.Second, 7)
+	for attempt := 1; attempt < 20; attempt++ {
+		if x, y := a.Next(attempt), b.Next(attempt); x != y {
+			t.Fatalf("Next(%d): %v и %v с одним seed", attempt, x, y)
+		}
+	}
+}
+
+func TestExponentialBackoffZeroBase(t *testing.T) {
+	b := NewExponentialBackoff(0, time.Second, 1)
+	if got := b.Next(math.MaxInt); got != 0 {
+		t.Fatalf("Next = %v с нулевым base, want 0", got)
+	}
+}
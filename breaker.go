@@ -0,0 +1,225 @@
+package pipeline
+
+import (
+	"errors"
+	"sync"
+	"time"
+)
+
+// ErrBreakerOpen возвращает функция, обёрнутая Breaker.Wrap, пока
+// автомат разомкнут.
+var ErrBreakerOpen = errors.New("автомат разомкнут")
+
+// BreakerState — состояние автомата Breaker.
+type BreakerState int
+
+const (
+	BreakerClosed   BreakerState = iota // вызовы проходят, ошибки считаются
+	BreakerOpen                         // вызовы не выполняются до конца паузы
+	BreakerHalfOpen                     // пропускается один пробный вызов
+)
+
+func (s BreakerState) String() string {
+	switch s {
+	case BreakerClosed:
+		return "closed"
+	case BreakerOpen:
+		return "open"
+	case BreakerHalfOpen:
+		return "half-open"
+	}
+	return "unknown"
+}
+
+// Breaker — автоматический выключатель для ненадёжной обработки.
+//
+// Вызовы считаются окнами по window штук. Если в окне доля ошибок
+// достигла threshold, автомат размыкается, и в течение cooldown
+// вызовы не выполняются. После паузы автомат пропускает один пробный
+// вызов: при успехе он замыкается, при ошибке снова размыкается.
+//
+// Автомат, созданный NewConsecutiveBreaker, вместо окон размыкается после
+// заданного количества ошибок подряд.
+type Breaker struct {
+	threshold   float64
+	window      int
+	consecutive int // ошибок подряд до размыкания, 0 — считать окнами
+	cooldown    time.Duration
+	backoff     Backoff // пауза после размыкания вместо cooldown, если задана
+	onChange    func(from, to BreakerState)
+	clock       Clock
+
+	mu       sync.Mutex
+	state    BreakerState
+	calls    int  // вызовов в текущем окне
+	failures int  // ошибок в текущем окне
+	streak   int  // ошибок подряд, если задан consecutive
+	probing  bool // пробный вызов уже выполняется
+	opens    int  // размыканий подряд без замыкания, номер попытки для backoff
+	openedAt time.Time
+	wait     time.Duration // пауза текущего размыкания
+}
+
+// NewBreaker создаёт замкнутый автомат. Функция onChange, если не nil,
+// вызывается при каждой смене состояния; она не должна обращаться к
+// самому автомату.
+func NewBreaker(threshold float64, window int, cooldown time.Duration, onChange func(from, to BreakerState)) *Breaker {
+	return &Breaker{
+		threshold: threshold,
+		window:    max(window, 1),
+		cooldown:  cooldown,
+		onChange:  onChange,
+		clock:     RealClock,
+	}
+}
+
+// NewConsecutiveBreaker создаёт замкнутый автомат, который размыкается
+// после threshold ошибок подряд (threshold меньше 1 считается равным 1);
+// успешный вызов обнуляет счёт. Остальное — как у NewBreaker.
+func NewConsecutiveBreaker(threshold int, cooldown time.Duration, onChange func(from, to BreakerState)) *Breaker {
+	b := NewBreaker(1, 1, cooldown, onChange)
+	b.consecutive = max(threshold, 1)
+	return b
+}
+
+// CircuitBreaker оборачивает process автоматом, размыкающимся после
+// threshold ошибок подряд (см. NewConsecutiveBreaker): пока он разомкнут,
+// обёртка в течение cooldown сразу возвращает ErrBreakerOpen, не вызывая
+// process, а затем пропускает один пробный вызов.
+func CircuitBreaker(process func(int64) (int64, error), threshold int, cooldown time.Duration) func(int64) (int64, error) {
+	return NewConsecutiveBreaker(threshold, cooldown, nil).Wrap(process)
+}
+
+// SetClock задаёт источник времени для отсчёта паузы после размыкания.
+// Вызывайте до начала работы с автоматом.
+func (b *Breaker) SetClock(c Clock) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.clock = c
+}
+
+// SetBackoff задаёт паузу после размыкания стратегией backoff вместо
+// постоянной cooldown: перед первой пробой ждётся backoff.Next(1), а если
+// проба не удалась и автомат снова разомкнулся — backoff.Next(2) и т.д.
+// Замыкание сбрасывает счёт попыток. nil возвращает постоянную паузу.
+// Вызывайте до начала работы с автоматом.
+func (b *Breaker) SetBackoff(backoff Backoff) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.backoff = backoff
+}
+
+// State возвращает текущее состояние автомата.
+func (b *Breaker) State() BreakerState {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	return b.state
+}
+
+// Allow сообщает, можно ли выполнить очередной вызов. Каждый
+// разрешённый вызов нужно завершить вызовом Record.
+func (b *Breaker) Allow() bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	switch b.state {
+	case BreakerOpen:
+		if b.clock.Now().Sub(b.openedAt) < b.wait {
+			return false
+		}
+		b.setState(BreakerHalfOpen)
+		fallthrough
+	case BreakerHalfOpen:
+		if b.probing {
+			return false
+		}
+		b.probing = true
+	}
+	return true
+}
+
+// Record учитывает результат разрешённого вызова. Результаты вызовов,
+// разрешённых до размыкания и завершившихся уже после него, не учитываются.
+func (b *Breaker) Record(err error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if b.state == BreakerOpen {
+		return
+	}
+	if b.state == BreakerHalfOpen {
+		b.probing = false
+		if err != nil {
+			b.open()
+		} else {
+			b.opens = 0
+			b.setState(BreakerClosed)
+		}
+		return
+	}
+
+	if b.consecutive > 0 {
+		if err == nil {
+			b.streak = 0
+			return
+		}
+		b.streak++
+		if b.streak >= b.consecutive {
+			b.open()
+		}
+		return
+	}
+
+	b.calls++
+	if err != nil {
+		b.failures++
+	}
+	if b.calls < b.window {
+		return
+	}
+	if float64(b.failures)/float64(b.calls) >= b.threshold {
+		b.open()
+		return
+	}
+	b.calls, b.failures = 0, 0
+}
+
+// Wrap возвращает fn, вызовы которой проходят через автомат. Пока
+// автомат разомкнут, обёртка сразу возвращает ErrBreakerOpen.
+func (b *Breaker) Wrap(fn func(int64) (int64, error)) func(int64) (int64, error) {
+	return func(v int64) (int64, error) {
+		if !b.Allow() {
+			return 0, ErrBreakerOpen
+		}
+		res, err := fn(v)
+		b.Record(err)
+		return res, err
+	}
+}
+
+// open размыкает автомат. Вызывается под b.mu.
+func (b *Breaker) open() {
+	b.calls, b.failures, b.streak = 0, 0, 0
+	b.opens++
+	b.wait = b.cooldown
+	if b.backoff != nil {
+		b.wait = b.backoff.Next(b.opens)
+	}
+	b.openedAt = b.clock.Now()
+	b.setState(BreakerOpen)
+}
+
+// setState меняет состояние и сообщает об этом onChange. Вызывается под b.mu.
+func (b *Breaker) setState(to BreakerState) {
+	from := b.state
+	if from == to {
+		return
+	}
+	b.state = to
+	if b.onChange != nil {
+		b.onChange(from, to)
+	}
+}
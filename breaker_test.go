@@ -0,0 +1,202 @@
+package pipeline
+
+import (
+	"errors"
+	"slices"
+	"testing"
+	"time"
+)
+
+func TestBreakerBackoff(t *testing.T) {
+	clock := NewManualClock(time.Unix(0, 0))
+	b := NewConsecutiveBreaker(1, time.Hour, nil)
+	b.SetClock(clock)
+	b.SetBackoff(LinearBackoff{Base: time.Second, Step: time.Second})
+	fail := b.Wrap(func(int64) (int64, error) { return 0, errors.New("сбой") })
+
+	fail(1)
+	if b.State() != BreakerOpen {
+		t.Fatalf("State = %v после сбоя, want %v", b.State(), BreakerOpen)
+	}
+	// первая пауза — Base, cooldown не используется
+	clock.Advance(time.Second)
+	if !b.Allow() {
+		t.Fatal("пробный вызов не разрешён через Base")
+	}
+
+	// пробный вызов не удался — вторая пауза на Step длиннее
+	b.Record(errors.New("сбой"))
+	clock.Advance(time.Second)
+	if b.Allow() {
+		t.Fatal("пробный вызов разрешён раньше второй паузы")
+	}
+	clock.Advance(time.Second)
+	if !b.Allow() {
+		t.Fatal("пробный вызов не разрешён через вторую паузу")
+	}
+}
+
+// transformAll прогоняет values через Transform и возвращает содержимое
+// out и dead.
+func transformAll(values []int64, fn func(int64) (int64, error)) (out, dead []int64) {
+	in := make(chan int64, len(values))
+	for _, v := range values {
+		in <- v
+	}
+	close(in)
+	outCh := make(chan int64, len(values))
+	deadCh := make(chan int64, len(values))
+	Transform(in, outCh, deadCh, fn)
+	close(deadCh)
+	for v := range outCh {
+		out = append(out, v)
+	}
+	for v := range deadCh {
+		dead = append(dead, v)
+	}
+	return out, dead
+}
+
+func TestBreakerTripsAndRecovers(t *testing.T) {
+	clock := NewManualClock(time.Unix(0, 0))
+	var transitions []BreakerState
+	b := NewBreaker(0.5, 4, time.Second, func(_, to BreakerState) {
+		transitions = append(transitions, to)
+	})
+	b.SetClock(clock)
+
+	failing := true
+	calls := 0
+	fn := b.Wrap(func(v int64) (int64, error) {
+		calls++
+		if failing {
+			return 0, errors.New("сбой")
+		}
+		return v, nil
+	})
+
+	// серия сбоев размыкает автомат, дальше fn не вызывается
+	out, dead := transformAll([]int64{1, 2, 3, 4, 5, 6, 7, 8}, fn)
+	if len(out) != 0 || len(dead) != 8 {
+		t.Fatalf("out = %v, dead = %v, want все числа в dead", out, dead)
+	}
+	if calls != 4 {
+		t.Fatalf("fn вызвана %d раз, want 4", calls)
+	}
+	if b.State() != BreakerOpen {
+		t.Fatalf("State = %v, want %v", b.State(), BreakerOpen)
+	}
+
+	// после паузы пробный вызов удаётся, и автомат замыкается
+	failing = false
+	clock.Advance(time.Second)
+	out, dead = transformAll([]int64{9, 10, 11}, fn)
+	if !slices.Equal(out, []int64{9, 10, 11}) || len(dead) != 0 {
+		t.Fatalf("out = %v, dead = %v, want [9 10 11] и пусто", out, dead)
+	}
+	if b.State() != BreakerClosed {
+		t.Fatalf("State = %v, want %v", b.State(), BreakerClosed)
+	}
+	want := []BreakerState{BreakerOpen, BreakerHalfOpen, BreakerClosed}
+	if !slices.Equal(transitions, want) {
+		t.Fatalf("переходы %v, want %v", transitions, want)
+	}
+}
+
+func TestConsecutiveBreakerTransitions(t *testing.T) {
+	errFail := errors.New("сбой")
+	clock := NewManualClock(time.Unix(0, 0))
+	b := NewConsecutiveBreaker(2, time.Second, nil)
+	b.SetClock(clock)
+
+	// call выполняет разрешённый вызов с результатом err
+	call := func(err error) bool {
+		if !b.Allow() {
+			return false
+		}
+		b.Record(err)
+		return true
+	}
+
+	tests := []struct {
+		name    string
+		advance time.Duration
+		err     error
+		allowed bool
+		want    BreakerState
+	}{
+		{"первый сбой", 0, errFail, true, BreakerClosed},
+		{"успех обнуляет счёт", 0, nil, true, BreakerClosed},
+		{"сбой", 0, errFail, true, BreakerClosed},
+		{"второй сбой подряд", 0, errFail, true, BreakerOpen},
+		{"разомкнут", 500 * time.Millisecond, nil, false, BreakerOpen},
+		{"проба не удалась", 500 * time.Millisecond, errFail, true, BreakerOpen},
+		{"снова разомкнут", 0, nil, false, BreakerOpen},
+		{"проба удалась", time.Second, nil, true, BreakerClosed},
+	}
+	for _, tt := range tests {
+		clock.Advance(tt.advance)
+		if got := call(tt.err); got != tt.allowed {
+			t.Fatalf("%s: вызов разрешён = %v, want %v", tt.name, got, tt.allowed)
+		}
+		if b.State() != tt.want {
+			t.Fatalf("%s: State = %v, want %v", tt.name, b.State(), tt.want)
+		}
+	}
+
+	// пока идёт проба, второй вызов не пропускается
+	call(errFail)
+	call(errFail)
+	clock.Advance(time.Second)
+	if !b.Allow() || b.State() != BreakerHalfOpen {
+		t.Fatalf("State = %v, want %v с разрешённой пробой", b.State(), BreakerHalfOpen)
+	}
+	if b.Allow() {
+		t.Fatal("второй вызов во время пробы разрешён")
+	}
+}
+
+func TestCircuitBreakerWrapper(t *testing.T) {
+	calls := 0
+	fn := CircuitBreaker(func(int64) (int64, error) {
+		calls++
+		return 0, errors.New("сбой")
+	}, 3, time.Hour)
+	for range 10 {
+		fn(1)
+	}
+	if calls != 3 {
+		t.Fatalf("process вызвана %d раз, want 3", calls)
+	}
+	if _, err := fn(1); !errors.Is(err, ErrBreakerOpen) {
+		t.Fatalf("ошибка %v, want %v", err, ErrBreakerOpen)
+	}
+}
+
+func TestBreakerLateRecordAfterOpen(t *testing.T) {
+	clock := NewManualClock(time.Unix(0, 0))
+	b := NewConsecutiveBreaker(2, time.Hour, nil)
+	b.SetClock(clock)
+	b.SetBackoff(LinearBackoff{Base: time.Second, Step: time.Second})
+	errFail := errors.New("сбой")
+
+	// четыре параллельных вызова разрешены, пока автомат замкнут
+	for range 4 {
+		if !b.Allow() {
+			t.Fatal("замкнутый автомат не пропустил вызов")
+		}
+	}
+	b.Record(errFail)
+	b.Record(errFail)
+	if b.State() != BreakerOpen {
+		t.Fatalf("State = %v, want BreakerOpen", b.State())
+	}
+	// запоздавшие результаты не продлевают паузу
+	b.Record(errFail)
+	b.Record(errFail)
+
+	clock.Advance(time.Second)
+	if !b.Allow() || b.State() != BreakerHalfOpen {
+		t.Fatalf("через backoff.Next(1) State = %v, want BreakerHalfOpen", b.State())
+	}
+}
@@ -0,0 +1,145 @@
+package pipeline
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+)
+
+// TestStagesStopOnCancel проверяет контракт отмены всех стадий: после
+// отмены контекста выходные каналы стадии закрываются. Стадии без
+// контекста (Filter, Transform) останавливаются закрытием входа.
+func TestStagesStopOnCancel(t *testing.T) {
+	const deadline = time.Second
+
+	tests := []struct {
+		name string
+		// start запускает стадию, читающую in, и возвращает её выходы
+		start func(ctx context.Context, in chan int64) []<-chan int64
+		// noCtx — стадия без контекста, её останавливает закрытие in
+		noCtx bool
+	}{
+		{
+			name: "Generator",
+			start: func(ctx context.Context, _ chan int64) []<-chan int64 {
+				out := make(chan int64)
+				go Generator(ctx, out, nil)
+				return []<-chan int64{out}
+			},
+		},
+		{
+			name: "Worker",
+			start: func(ctx context.Context, in chan int64) []<-chan int64 {
+				out := make(chan int64)
+				go Worker(ctx, in, out)
+				return []<-chan int64{out}
+			},
+		},
+		{
+			name: "Transform",
+			start: func(_ context.Context, in chan int64) []<-chan int64 {
+				out := make(chan int64)
+				go Transform(in, out, make(chan int64), func(v int64) (int64, error) { return v, nil })
+				return []<-chan int64{out}
+			},
+			noCtx: true,
+		},
+		{
+			name: "Filter",
+			start: func(_ context.Context, in chan int64) []<-chan int64 {
+				out := make(chan int64)
+				go Filter(in, out, func(int64) bool { return true })
+				return []<-chan int64{out}
+			},
+			noCtx: true,
+		},
+		{
+			name: "FanIn",
+			start: func(ctx context.Context, in chan int64) []<-chan int64 {
+				// второй вход никогда не закрывается
+				return []<-chan int64{FanIn(ctx, in, make(chan int64))}
+			},
+		},
+		{
+			name: "fanOut",
+			start: func(ctx context.Context, in chan int64) []<-chan int64 {
+				p := NewPipeline(3)
+				outs, _ := p.fanOut(ctx, []<-chan int64{in, in, in}, func(int) []WorkerOption { return nil }, false, p.logger)
+				res := make([]<-chan int64, len(outs))
+				for i, out := range outs {
+					res[i] = out
+				}
+				return res
+			},
+		},
+		{
+			name: "HashFanOut",
+			start: func(ctx context.Context, in chan int64) []<-chan int64 {
+				outs := make([]chan<- int64, 3)
+				res := make([]<-chan int64, len(outs))
+				for i := range outs {
+					out := make(chan int64)
+					outs[i], res[i] = out, out
+				}
+				go HashFanOut(ctx, in, outs, MixHash)
+				return res
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+
+			// на вход идут числа, пока стадию не остановят
+			in := make(chan int64)
+			stopFeed := make(chan struct{})
+			fed := make(chan struct{})
+			go func() {
+				defer close(fed)
+				for v := int64(1); ; v++ {
+					select {
+					case <-stopFeed:
+						return
+					case in <- v:
+					}
+				}
+			}()
+
+			outs := tt.start(ctx, in)
+			// стадия успевает поработать до остановки
+			time.Sleep(5 * time.Millisecond)
+			if tt.noCtx {
+				close(stopFeed)
+				<-fed
+				close(in)
+			} else {
+				cancel()
+			}
+
+			var wg sync.WaitGroup
+			for _, out := range outs {
+				wg.Add(1)
+				go func() {
+					defer wg.Done()
+					for range out {
+					}
+				}()
+			}
+			closed := make(chan struct{})
+			go func() {
+				wg.Wait()
+				close(closed)
+			}()
+			select {
+			case <-closed:
+			case <-time.After(deadline):
+				t.Fatalf("выход %s не закрылся за %v после остановки", tt.name, deadline)
+			}
+			if !tt.noCtx {
+				close(stopFeed)
+			}
+		})
+	}
+}
@@ -0,0 +1,183 @@
+package pipeline
+
+import (
+	"sync"
+	"time"
+)
+
+// Clock — источник времени для стадий, которые ждут или измеряют время.
+// В тестах вместо RealClock можно передать ManualClock и управлять
+// временем вручную, не полагаясь на time.Sleep.
+type Clock interface {
+	Now() time.Time
+	After(d time.Duration) <-chan time.Time
+	NewTicker(d time.Duration) Ticker
+}
+
+// Ticker — периодический таймер, созданный Clock.NewTicker.
+type Ticker interface {
+	C() <-chan time.Time
+	Stop()
+}
+
+// RealClock — Clock на основе пакета time.
+var RealClock Clock = realClock{}
+
+type realClock struct{}
+
+func (realClock) Now() time.Time                         { return time.Now() }
+func (realClock) After(d time.Duration) <-chan time.Time { return time.After(d) }
+func (realClock) NewTicker(d time.Duration) Ticker       { return realTicker{time.NewTicker(d)} }
+
+func (realClock) afterStop(d time.Duration) (<-chan time.Time, func()) {
+	t := time.NewTimer(d)
+	return t.C, func() { t.Stop() }
+}
+
+// stopClock — Clock, таймер которого можно остановить до срабатывания.
+// Его реализуют RealClock и ManualClock: у ManualClock остановленный таймер
+// перестаёт числиться в Waiters.
+type stopClock interface {
+	afterStop(d time.Duration) (<-chan time.Time, func())
+}
+
+// afterStop работает как clock.After, но возвращает ещё и функцию,
+// останавливающую таймер, если он больше не нужен. Для часов без такой
+// возможности она ничего не делает.
+func afterStop(clock Clock, d time.Duration) (<-chan time.Time, func()) {
+	if c, ok := clock.(stopClock); ok {
+		return c.afterStop(d)
+	}
+	return clock.After(d), func() {}
+}
+
+type realTicker struct {
+	t *time.Ticker
+}
+
+func (t realTicker) C() <-chan time.Time { return t.t.C }
+func (t realTicker) Stop()               { t.t.Stop() }
+
+// ManualClock — Clock, время которого идёт только при вызове Advance.
+// Таймеры и тикеры срабатывают, когда Advance переводит часы на их
+// момент или дальше. Как и у time.Ticker, пропущенные срабатывания
+// тикера не копятся: за один Advance тикер срабатывает не больше раза.
+type ManualClock struct {
+	mu      sync.Mutex
+	now     time.Time
+	waiters []*manualWaiter
+}
+
+// manualWaiter — ожидающий таймер или тикер ManualClock.
+type manualWaiter struct {
+	at     time.Time
+	period time.Duration // 0 для одноразового таймера
+	ch     chan time.Time
+}
+
+// NewManualClock создаёт ManualClock, показывающий время start.
+func NewManualClock(start time.Time) *ManualClock {
+	return &ManualClock{now: start}
+}
+
+// Now возвращает текущее время часов.
+func (c *ManualClock) Now() time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return c.now
+}
+
+// After возвращает канал, в который придёт время, когда часы дойдут до
+// Now()+d. При d <= 0 канал срабатывает сразу.
+func (c *ManualClock) After(d time.Duration) <-chan time.Time {
+	ch, _ := c.afterStop(d)
+	return ch
+}
+
+// afterStop работает как After, а возвращённая функция убирает таймер из
+// ожидающих.
+func (c *ManualClock) afterStop(d time.Duration) (<-chan time.Time, func()) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	w := &manualWaiter{at: c.now.Add(d), ch: make(chan time.Time, 1)}
+	if d <= 0 {
+		w.ch <- c.now
+		return w.ch, func() {}
+	}
+	c.waiters = append(c.waiters, w)
+	return w.ch, func() { c.remove(w) }
+}
+
+// NewTicker создаёт тикер с периодом d. Как и time.NewTicker,
+// паникует при d <= 0.
+func (c *ManualClock) NewTicker(d time.Duration) Ticker {
+	if d <= 0 {
+		panic("ManualClock.NewTicker: период должен быть положительным")
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	w := &manualWaiter{at: c.now.Add(d), period: d, ch: make(chan time.Time, 1)}
+	c.waiters = append(c.waiters, w)
+	return &manualTicker{c: c, w: w}
+}
+
+// Advance переводит часы вперёд на d и запускает наступившие таймеры.
+func (c *ManualClock) Advance(d time.Duration) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.now = c.now.Add(d)
+	waiters := c.waiters[:0]
+	for _, w := range c.waiters {
+		if w.at.After(c.now) {
+			waiters = append(waiters, w)
+			continue
+		}
+		select {
+		case w.ch <- c.now:
+		default:
+		}
+		if w.period > 0 {
+			for !w.at.After(c.now) {
+				w.at = w.at.Add(w.period)
+			}
+			waiters = append(waiters, w)
+		}
+	}
+	c.waiters = waiters
+}
+
+// Waiters возвращает количество таймеров и тикеров, которые ждут
+// Advance; паузы воркеров и стадий, прерванные отменой контекста, сюда не
+// входят. По нему тест может дождаться, пока стадия начнёт ждать, и
+// только потом переводить часы.
+func (c *ManualClock) Waiters() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return len(c.waiters)
+}
+
+// remove удаляет w из ожидающих.
+func (c *ManualClock) remove(w *manualWaiter) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	for i, x := range c.waiters {
+		if x == w {
+			c.waiters = append(c.waiters[:i], c.waiters[i+1:]...)
+			return
+		}
+	}
+}
+
+type manualTicker struct {
+	c *ManualClock
+	w *manualWaiter
+}
+
+func (t *manualTicker) C() <-chan time.Time { return t.w.ch }
+func (t *manualTicker) Stop()               { t.c.remove(t.w) }
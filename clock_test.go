@@ -0,0 +1,145 @@
+package pipeline
+
+import (
+	"context"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// waitForWaiters ждёт, пока на часах c начнут ждать n таймеров.
+func waitForWaiters(t *testing.T, c *ManualClock, n int) {
+	t.Helper()
+	deadline := time.Now().Add(2 * time.Second)
+	for c.Waiters() < n {
+		if time.Now().After(deadline) {
+			t.Fatalf("на часах ждут %d таймеров, want %d", c.Waiters(), n)
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+func TestManualClock(t *testing.T) {
+	start := time.Unix(0, 0)
+	c := NewManualClock(start)
+
+	after := c.After(10 * time.Millisecond)
+	ticker := c.NewTicker(4 * time.Millisecond)
+
+	c.Advance(5 * time.Millisecond)
+	select {
+	case <-after:
+		t.Fatal("таймер сработал раньше срока")
+	default:
+	}
+	if at := <-ticker.C(); !at.Equal(start.Add(5 * time.Millisecond)) {
+		t.Fatalf("тикер сработал в %v", at)
+	}
+
+	// за один Advance тикер срабатывает не больше раза
+	c.Advance(10 * time.Millisecond)
+	if at := <-after; !at.Equal(start.Add(15 * time.Millisecond)) {
+		t.Fatalf("таймер сработал в %v", at)
+	}
+	<-ticker.C()
+	select {
+	case <-ticker.C():
+		t.Fatal("пропущенные срабатывания тикера накопились")
+	default:
+	}
+
+	ticker.Stop()
+	if c.Waiters() != 0 {
+		t.Fatalf("Waiters = %d после Stop, want 0", c.Waiters())
+	}
+	if !c.Now().Equal(start.Add(15 * time.Millisecond)) {
+		t.Fatalf("Now = %v", c.Now())
+	}
+}
+
+func TestWatchdogManualClock(t *testing.T) {
+	clock := NewManualClock(time.Unix(0, 0))
+	var n atomic.Int64
+	calls := make(chan struct{}, 1)
+	progress := func() int64 {
+		calls <- struct{}{}
+		return n.Load()
+	}
+	cancelled := make(chan struct{})
+	cancel := func() { close(cancelled) }
+
+	go watchdog(context.Background(), cancel, progress, 40*time.Millisecond, clock)
+	<-calls
+	waitForWaiters(t, clock, 1)
+
+	// счётчик вырос — отсчёт начинается заново
+	n.Add(1)
+	clock.Advance(40 * time.Millisecond)
+	<-calls
+	clock.Advance(20 * time.Millisecond)
+	<-calls
+	select {
+	case <-cancelled:
+		t.Fatal("watchdog сработал, пока счётчик рос")
+	default:
+	}
+
+	clock.Advance(20 * time.Millisecond)
+	<-calls
+	select {
+	case <-cancelled:
+	case <-time.After(2 * time.Second):
+		t.Fatal("watchdog не сработал через idle без прогресса")
+	}
+}
+
+func TestProgressIntervalManualClock(t *testing.T) {
+	clock := NewManualClock(time.Unix(0, 0))
+	progress := make(chan Stats)
+	p := NewPipeline(2, WithPipelineClock(clock), WithWorkerOptions(WithJitter(0, 0)),
+		WithProgressInterval(10*time.Millisecond, progress))
+
+	if err := p.Start(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	waitForWaiters(t, clock, 1)
+	select {
+	case <-progress:
+		t.Fatal("снимок отправлен раньше интервала")
+	default:
+	}
+
+	// каждый Advance на интервал выдаёт ровно один снимок
+	var last int64
+	for range 3 {
+		clock.Advance(10 * time.Millisecond)
+		snap := <-progress
+		if snap.InputCount < last {
+			t.Fatalf("InputCount снимка уменьшился: %d после %d", snap.InputCount, last)
+		}
+		last = snap.InputCount
+	}
+
+	p.Close()
+	s := p.Wait()
+	if err := CheckInvariants(s); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestManualClockCancelledSleep(t *testing.T) {
+	clock := NewManualClock(time.Unix(0, 0))
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan bool)
+	go func() { done <- sleep(ctx, clock, time.Second) }()
+	waitForWaiters(t, clock, 1)
+
+	cancel()
+	if <-done {
+		t.Fatal("sleep не заметил отмену")
+	}
+	// прерванная пауза больше не числится среди ожидающих
+	if n := clock.Waiters(); n != 0 {
+		t.Fatalf("Waiters после отмены = %d, want 0", n)
+	}
+}
@@ -0,0 +1,51 @@
+// Команда example запускает конвейер из пакета pipeline как обычную
+// программу: конфигурация читается из флагов и переменных окружения
+// PIPELINE_*, итог печатается и проверяется.
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"os/signal"
+	"syscall"
+
+	"github.com/Alex-Nosov-ITMO/go-project-sprint-9"
+)
+
+func main() {
+	cfg, err := pipeline.LoadConfig()
+	if errors.Is(err, flag.ErrHelp) {
+		// справку по флагам уже напечатал разбор командной строки
+		os.Exit(0)
+	}
+	if err != nil {
+		log.Fatalf("Ошибка: %v\n", err)
+	}
+
+	// Ctrl-C и SIGTERM отменяют контекст так же, как истечение времени:
+	// генератор останавливается, а уже выданные числа дообрабатываются
+	sigCtx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	s, err := pipeline.Run(sigCtx, cfg, pipeline.WithLatency())
+	if err != nil {
+		log.Fatalf("Ошибка: %v\n", err)
+	}
+
+	if !errors.Is(s.StopReason, pipeline.ErrRunTimeout) {
+		fmt.Println("Остановлено по сигналу")
+	}
+	fmt.Println("Количество чисел", s.InputCount, s.OutputCount)
+	fmt.Println("Сумма чисел", s.InputSum, s.OutputSum)
+	fmt.Println("Разбивка по каналам", s.PerChannel)
+	fmt.Println("Задержка min/p50/p95/max", s.Latency.Min, s.Latency.P50, s.Latency.P95, s.Latency.Max)
+
+	// проверка результатов
+	if err := pipeline.CheckInvariants(s); err != nil {
+		log.Fatalf("Ошибка: %v\n", err)
+	}
+}
@@ -0,0 +1,46 @@
+package pipeline
+
+import "sync/atomic"
+
+// Collector собирает итог по числам результирующего канала, см.
+// WithCollector: сумму, количество, среднее, гистограмму и т.д. Add
+// вызывается для каждого числа, а Result возвращает итог по всем
+// добавленным числам. При нескольких потребителях (WithConsumers) Add
+// вызывается из разных горутин одновременно.
+type Collector interface {
+	Add(v int64)
+	Result() any
+}
+
+// SumCollector — Collector суммы чисел; Result возвращает int64. Нулевое
+// значение готово к работе, методы безопасны для параллельного вызова.
+type SumCollector struct {
+	sum atomic.Int64
+}
+
+// Add прибавляет v к сумме.
+func (c *SumCollector) Add(v int64) {
+	c.sum.Add(v)
+}
+
+// Result возвращает сумму добавленных чисел как int64.
+func (c *SumCollector) Result() any {
+	return c.sum.Load()
+}
+
+// CountCollector — Collector количества чисел; Result возвращает int64.
+// Нулевое значение готово к работе, методы безопасны для параллельного
+// вызова.
+type CountCollector struct {
+	n atomic.Int64
+}
+
+// Add учитывает число v.
+func (c *CountCollector) Add(int64) {
+	c.n.Add(1)
+}
+
+// Result возвращает количество добавленных чисел как int64.
+func (c *CountCollector) Result() any {
+	return c.n.Load()
+}
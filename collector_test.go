@@ -0,0 +1,52 @@
+package pipeline
+
+import (
+	"context"
+	"sync"
+	"testing"
+)
+
+// avgCollector — пользовательский Collector среднего значения чисел.
+type avgCollector struct {
+	mu       sync.Mutex
+	sum, cnt int64
+}
+
+func (c *avgCollector) Add(v int64) {
+	c.mu.Lock()
+	c.sum += v
+	c.cnt++
+	c.mu.Unlock()
+}
+
+func (c *avgCollector) Result() any {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.cnt == 0 {
+		return 0.0
+	}
+	return float64(c.sum) / float64(c.cnt)
+}
+
+func TestCustomAverageCollector(t *testing.T) {
+	values := make([]int64, 100)
+	for i := range values {
+		values[i] = int64(i + 1)
+	}
+	var avg avgCollector
+	var sum SumCollector
+	var count CountCollector
+	s := NewPipeline(4, WithSource(values), WithConsumers(3),
+		WithCollector(&avg, &sum, &count)).Run(context.Background())
+
+	if got := avg.Result(); got != 50.5 {
+		t.Fatalf("среднее = %v, want 50.5", got)
+	}
+	// встроенные коллекторы сходятся со статистикой запуска
+	if got := sum.Result(); got != s.OutputSum {
+		t.Fatalf("SumCollector = %v, want %d", got, s.OutputSum)
+	}
+	if got := count.Result(); got != s.OutputCount {
+		t.Fatalf("CountCollector = %v, want %d", got, s.OutputCount)
+	}
+}
@@ -0,0 +1,240 @@
+package pipeline
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"os"
+	"strconv"
+	"time"
+)
+
+// Config — параметры запуска конвейера, собранные в одном месте: их
+// читает из командной строки LoadConfig и принимает Run.
+type Config struct {
+	Workers       int           // количество воркеров
+	Duration      time.Duration // сколько времени генерировать числа
+	InputBuffer   int           // размер буфера общего канала, см. WithInputBuffer
+	OutputBuffer  int           // размер буфера результирующего канала, 0 — по количеству воркеров, см. WithOutputBuffer
+	WorkerDelay   time.Duration // пауза воркера после каждого числа
+	Deterministic bool          // воспроизводимый режим, см. WithDeterministic
+	MaxInFlight   int           // предел чисел в пути, 0 — без предела, см. WithMaxInFlight
+}
+
+// DefaultConfig возвращает параметры по умолчанию: 5 воркеров, 1 секунда,
+// каналы без собственных буферов, пауза 1 мс.
+func DefaultConfig() Config {
+	return Config{
+		Workers:     5,
+		Duration:    time.Second,
+		WorkerDelay: time.Millisecond,
+	}
+}
+
+// Validate проверяет cfg: количество воркеров и длительность должны быть
+// положительными, а буферы, пауза и предел чисел в пути — неотрицательными. Возвращает ошибку
+// с описанием первого нарушения или nil.
+func (cfg Config) Validate() error {
+	if cfg.Workers < 1 {
+		return fmt.Errorf("количество воркеров должно быть положительным: %d", cfg.Workers)
+	}
+	if cfg.Duration <= 0 {
+		return fmt.Errorf("длительность должна быть положительной: %v", cfg.Duration)
+	}
+	if cfg.InputBuffer < 0 {
+		return fmt.Errorf("размер буфера не может быть отрицательным: %d", cfg.InputBuffer)
+	}
+	if cfg.OutputBuffer < 0 {
+		return fmt.Errorf("размер выходного буфера не может быть отрицательным: %d", cfg.OutputBuffer)
+	}
+	if cfg.WorkerDelay < 0 {
+		return fmt.Errorf("пауза воркера не может быть отрицательной: %v", cfg.WorkerDelay)
+	}
+	if cfg.MaxInFlight < 0 {
+		return fmt.Errorf("предел чисел в пути не может быть отрицательным: %d", cfg.MaxInFlight)
+	}
+	return nil
+}
+
+// Run проверяет cfg и запускает по нему конвейер на cfg.Duration, как
+// RunFor. Опции opts применяются после опций cfg и могут их дополнить.
+func Run(ctx context.Context, cfg Config, opts ...Option) (Stats, error) {
+	if err := cfg.Validate(); err != nil {
+		return Stats{}, err
+	}
+	opts = append(cfg.Options(), opts...)
+	return NewPipeline(cfg.Workers, opts...).RunFor(ctx, cfg.Duration)
+}
+
+// RunStream работает как Run, но не ждёт конца запуска, а сразу
+// возвращает результирующий канал, чтобы передать его дальше — например,
+// на вход второго конвейера через WithInputSource или в стадии вроде Map.
+// Канал закрывается, когда запуск завершён и все числа отправлены в него;
+// читать его нужно до конца, иначе запуск не завершится. stats ждёт
+// закрытия канала и возвращает итоговую статистику; вызывать её можно
+// сколько угодно раз. Если cfg неверен, канал сразу закрывается, а
+// ошибка проверки попадает в Stats.Err. После отмены ctx числа, которые
+// никто не забрал, отбрасываются, чтобы запуск не завис без читателя;
+// в OutputCount они всё равно учтены.
+func RunStream(ctx context.Context, cfg Config, opts ...Option) (out <-chan int64, stats func() Stats) {
+	ch := make(chan int64)
+	done := make(chan struct{})
+	var s Stats
+	stats = func() Stats {
+		<-done
+		return s
+	}
+
+	if err := cfg.Validate(); err != nil {
+		s.Err = err
+		close(ch)
+		close(done)
+		return ch, stats
+	}
+	opts = append(cfg.Options(), opts...)
+	opts = append(opts, WithCollector(streamCollector{ctx, ch}))
+	go func() {
+		defer close(done)
+		defer close(ch)
+
+		s, _ = NewPipeline(cfg.Workers, opts...).RunFor(ctx, cfg.Duration)
+	}()
+	return ch, stats
+}
+
+// streamCollector пересылает числа результирующего канала в ch для
+// RunStream; после отмены ctx непринятое число отбрасывается.
+type streamCollector struct {
+	ctx context.Context
+	ch  chan<- int64
+}
+
+// Add отправляет v в канал потока.
+func (c streamCollector) Add(v int64) {
+	select {
+	case c.ch <- v:
+	case <-c.ctx.Done():
+	}
+}
+
+// Result возвращает nil: итог потока — сами числа.
+func (c streamCollector) Result() any { return nil }
+
+// Переменные окружения, которые читает LoadConfig.
+const (
+	envWorkers       = "PIPELINE_WORKERS"
+	envDuration      = "PIPELINE_DURATION"
+	envBuffer        = "PIPELINE_BUFFER"
+	envOutputBuffer  = "PIPELINE_OUTPUT_BUFFER"
+	envWorkerDelay   = "PIPELINE_WORKER_DELAY"
+	envDeterministic = "PIPELINE_DETERMINISTIC"
+	envMaxInFlight   = "PIPELINE_MAX_IN_FLIGHT"
+)
+
+// LoadConfig читает Config из флагов командной строки и переменных
+// окружения PIPELINE_*. Флаги важнее переменных окружения, а те — значений
+// DefaultConfig. Результат проверяется через Config.Validate.
+func LoadConfig() (Config, error) {
+	return loadConfig(os.Args[1:], os.Getenv)
+}
+
+// loadConfig разбирает args и переменные окружения, которые возвращает getenv.
+func loadConfig(args []string, getenv func(string) string) (Config, error) {
+	cfg := DefaultConfig()
+
+	var err error
+	if cfg.Workers, err = intEnv(getenv, envWorkers, cfg.Workers); err != nil {
+		return Config{}, err
+	}
+	if cfg.Duration, err = durationEnv(getenv, envDuration, cfg.Duration); err != nil {
+		return Config{}, err
+	}
+	if cfg.InputBuffer, err = intEnv(getenv, envBuffer, cfg.InputBuffer); err != nil {
+		return Config{}, err
+	}
+	if cfg.OutputBuffer, err = intEnv(getenv, envOutputBuffer, cfg.OutputBuffer); err != nil {
+		return Config{}, err
+	}
+	if cfg.WorkerDelay, err = durationEnv(getenv, envWorkerDelay, cfg.WorkerDelay); err != nil {
+		return Config{}, err
+	}
+	if cfg.Deterministic, err = boolEnv(getenv, envDeterministic, cfg.Deterministic); err != nil {
+		return Config{}, err
+	}
+	if cfg.MaxInFlight, err = intEnv(getenv, envMaxInFlight, cfg.MaxInFlight); err != nil {
+		return Config{}, err
+	}
+
+	fs := flag.NewFlagSet("pipeline", flag.ContinueOnError)
+	fs.IntVar(&cfg.Workers, "workers", cfg.Workers, "количество воркеров")
+	fs.DurationVar(&cfg.Duration, "duration", cfg.Duration, "сколько времени генерировать числа")
+	fs.IntVar(&cfg.InputBuffer, "buffer", cfg.InputBuffer, "размер буфера общего канала")
+	fs.IntVar(&cfg.OutputBuffer, "output-buffer", cfg.OutputBuffer, "размер буфера результирующего канала")
+	fs.DurationVar(&cfg.WorkerDelay, "worker-delay", cfg.WorkerDelay, "пауза воркера после каждого числа")
+	fs.BoolVar(&cfg.Deterministic, "deterministic", cfg.Deterministic, "воспроизводимый режим")
+	fs.IntVar(&cfg.MaxInFlight, "max-in-flight", cfg.MaxInFlight, "предел чисел в пути, 0 — без предела")
+	if err := fs.Parse(args); err != nil {
+		return Config{}, err
+	}
+
+	if err := cfg.Validate(); err != nil {
+		return Config{}, err
+	}
+	return cfg, nil
+}
+
+// Options возвращает опции Pipeline, соответствующие cfg. Количество
+// воркеров и длительность передаются в NewPipeline и RunFor отдельно.
+func (cfg Config) Options() []Option {
+	opts := []Option{
+		WithInputBuffer(cfg.InputBuffer),
+		WithOutputBuffer(cfg.OutputBuffer),
+		WithWorkerOptions(WithJitter(cfg.WorkerDelay, 0)),
+		WithMaxInFlight(int64(cfg.MaxInFlight)),
+	}
+	if cfg.Deterministic {
+		opts = append(opts, WithDeterministic())
+	}
+	return opts
+}
+
+// intEnv возвращает целое из переменной окружения name или def, если она пуста.
+func intEnv(getenv func(string) string, name string, def int) (int, error) {
+	s := getenv(name)
+	if s == "" {
+		return def, nil
+	}
+	v, err := strconv.Atoi(s)
+	if err != nil {
+		return 0, fmt.Errorf("%s: %w", name, err)
+	}
+	return v, nil
+}
+
+// boolEnv возвращает логическое значение из переменной окружения name или
+// def, если она пуста.
+func boolEnv(getenv func(string) string, name string, def bool) (bool, error) {
+	s := getenv(name)
+	if s == "" {
+		return def, nil
+	}
+	v, err := strconv.ParseBool(s)
+	if err != nil {
+		return false, fmt.Errorf("%s: %w", name, err)
+	}
+	return v, nil
+}
+
+// durationEnv возвращает длительность из переменной окружения name или
+// def, если она пуста.
+func durationEnv(getenv func(string) string, name string, def time.Duration) (time.Duration, error) {
+	s := getenv(name)
+	if s == "" {
+		return def, nil
+	}
+	v, err := time.ParseDuration(s)
+	if err != nil {
+		return 0, fmt.Errorf("%s: %w", name, err)
+	}
+	return v, nil
+}
@@ -0,0 +1,272 @@
+package pipeline
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"os"
+	"testing"
+	"time"
+)
+
+func env(m map[string]string) func(string) string {
+	return func(name string) string { return m[name] }
+}
+
+func TestLoadConfigFlags(t *testing.T) {
+	cfg, err := loadConfig([]string{
+		"-workers", "3",
+		"-duration", "250ms",
+		"-buffer", "16",
+		"-output-buffer", "8",
+		"-worker-delay", "0s",
+		"-deterministic",
+		"-max-in-flight", "100",
+	}, env(nil))
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := Config{
+		Workers:       3,
+		Duration:      250 * time.Millisecond,
+		InputBuffer:   16,
+		OutputBuffer:  8,
+		Deterministic: true,
+		MaxInFlight:   100,
+	}
+	if cfg != want {
+		t.Fatalf("loadConfig = %+v, want %+v", cfg, want)
+	}
+}
+
+func TestLoadConfigDefaults(t *testing.T) {
+	cfg, err := loadConfig(nil, env(nil))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cfg != DefaultConfig() {
+		t.Fatalf("loadConfig = %+v, want %+v", cfg, DefaultConfig())
+	}
+}
+
+func TestConfigValidate(t *testing.T) {
+	tests := []struct {
+		name    string
+		edit    func(*Config)
+		wantErr bool
+	}{
+		{"defaults", func(*Config) {}, false},
+		{"zero buffers and delay", func(c *Config) { c.InputBuffer, c.OutputBuffer, c.WorkerDelay = 0, 0, 0 }, false},
+		{"zero workers", func(c *Config) { c.Workers = 0 }, true},
+		{"negative workers", func(c *Config) { c.Workers = -3 }, true},
+		{"zero duration", func(c *Config) { c.Duration = 0 }, true},
+		{"negative duration", func(c *Config) { c.Duration = -time.Second }, true},
+		{"negative buffer", func(c *Config) { c.InputBuffer = -1 }, true},
+		{"negative output buffer", func(c *Config) { c.OutputBuffer = -1 }, true},
+		{"negative worker delay", func(c *Config) { c.WorkerDelay = -time.Millisecond }, true},
+		{"negative max in flight", func(c *Config) { c.MaxInFlight = -1 }, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := DefaultConfig()
+			tt.edit(&cfg)
+			if err := cfg.Validate(); (err != nil) != tt.wantErr {
+				t.Fatalf("Validate(%+v) = %v, want ошибку: %v", cfg, err, tt.wantErr)
+			}
+			// Run не запускает конвейер с неверными параметрами
+			if tt.wantErr {
+				if _, err := Run(context.Background(), cfg); err == nil {
+					t.Fatalf("Run(%+v) без ошибки", cfg)
+				}
+			}
+		})
+	}
+}
+
+func TestDefaultConfig(t *testing.T) {
+	want := Config{Workers: 5, Duration: time.Second, WorkerDelay: time.Millisecond}
+	if cfg := DefaultConfig(); cfg != want {
+		t.Fatalf("DefaultConfig() = %+v, want %+v", cfg, want)
+	}
+}
+
+func TestLoadConfigEnv(t *testing.T) {
+	getenv := env(map[string]string{
+		envWorkers:     "7",
+		envDuration:    "2s",
+		envWorkerDelay: "5ms",
+	})
+
+	cfg, err := loadConfig(nil, getenv)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cfg.Workers != 7 || cfg.Duration != 2*time.Second || cfg.WorkerDelay != 5*time.Millisecond {
+		t.Fatalf("loadConfig = %+v", cfg)
+	}
+
+	// флаг важнее переменной окружения
+	cfg, err = loadConfig([]string{"-workers", "2"}, getenv)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cfg.Workers != 2 || cfg.Duration != 2*time.Second {
+		t.Fatalf("loadConfig = %+v", cfg)
+	}
+}
+
+func TestLoadConfigErrors(t *testing.T) {
+	tests := []struct {
+		name string
+		args []string
+		env  map[string]string
+	}{
+		{"zero workers", []string{"-workers", "0"}, nil},
+		{"negative duration", []string{"-duration", "-1s"}, nil},
+		{"negative buffer", []string{"-buffer", "-1"}, nil},
+		{"negative max in flight", []string{"-max-in-flight", "-5"}, nil},
+		{"bad env int", nil, map[string]string{envWorkers: "five"}},
+		{"bad env duration", nil, map[string]string{envDuration: "soon"}},
+		{"bad env bool", nil, map[string]string{envDeterministic: "maybe"}},
+		{"zero workers env", nil, map[string]string{envWorkers: "0"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := loadConfig(tt.args, env(tt.env)); err == nil {
+				t.Fatal("loadConfig вернул nil")
+			}
+		})
+	}
+}
+
+func TestLoadConfigHelp(t *testing.T) {
+	// справка печатается в stderr, в тесте она не нужна
+	stderr := os.Stderr
+	devnull, err := os.OpenFile(os.DevNull, os.O_WRONLY, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	os.Stderr = devnull
+	defer func() {
+		os.Stderr = stderr
+		devnull.Close()
+	}()
+
+	_, err = loadConfig([]string{"-h"}, env(nil))
+	if !errors.Is(err, flag.ErrHelp) {
+		t.Fatalf("loadConfig(-h) = %v, want flag.ErrHelp", err)
+	}
+}
+
+func TestRunStoppedBySignal(t *testing.T) {
+	// отмена контекста имитирует SIGINT, который в main отменяет sigCtx
+	sigCtx, stop := context.WithCancel(context.Background())
+	time.AfterFunc(20*time.Millisecond, stop)
+
+	cfg := DefaultConfig()
+	cfg.Duration = time.Minute
+	start := time.Now()
+	s, err := Run(sigCtx, cfg, WithLatency())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if elapsed := time.Since(start); elapsed > 5*time.Second {
+		t.Fatalf("Run остановился через %v после сигнала", elapsed)
+	}
+	if !errors.Is(s.StopReason, context.Canceled) {
+		t.Fatalf("StopReason = %v, want %v", s.StopReason, context.Canceled)
+	}
+	if s.InputCount == 0 {
+		t.Fatal("InputCount = 0")
+	}
+	if err := CheckInvariants(s); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// chanSource — Source, читающий числа из канала до его закрытия.
+type chanSource <-chan int64
+
+func (c chanSource) Next() (int64, bool) {
+	v, ok := <-c
+	return v, ok
+}
+
+func TestRunStreamChain(t *testing.T) {
+	cfg := DefaultConfig()
+	cfg.Workers, cfg.Duration, cfg.WorkerDelay = 3, 20*time.Millisecond, 0
+	out, stats := RunStream(context.Background(), cfg)
+
+	// выход первого конвейера — вход второго
+	second := NewPipeline(2, WithInputSource(chanSource(out)),
+		WithPoolProcess(func(v int64) int64 { return 2 * v })).Run(context.Background())
+	first := stats()
+
+	if first.Err != nil || second.Err != nil {
+		t.Fatalf("ошибки запусков: %v, %v", first.Err, second.Err)
+	}
+	if first.OutputCount == 0 {
+		t.Fatal("первый конвейер ничего не выдал")
+	}
+	if second.InputCount != first.OutputCount || second.OutputCount != first.OutputCount {
+		t.Fatalf("второй конвейер: InputCount = %d, OutputCount = %d, want %d",
+			second.InputCount, second.OutputCount, first.OutputCount)
+	}
+	if second.OutputSum != 2*first.OutputSum {
+		t.Fatalf("второй конвейер: OutputSum = %d, want %d", second.OutputSum, 2*first.OutputSum)
+	}
+	for _, s := range []Stats{first, second} {
+		if err := CheckInvariants(s); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// неверный Config закрывает поток сразу
+	out, stats = RunStream(context.Background(), Config{})
+	if _, ok := <-out; ok {
+		t.Fatal("поток с неверным Config не закрыт")
+	}
+	if stats().Err == nil {
+		t.Fatal("Stats.Err = nil для неверного Config")
+	}
+}
+
+// countingSource — Source чисел 1, 2, 3 и т.д., который при каждом Next
+// считает, сколько выданных им чисел ещё не дошло до выхода по m.
+type countingSource struct {
+	m           *Metrics
+	taken, peak int64
+}
+
+func (c *countingSource) Next() (int64, bool) {
+	c.taken++
+	// выход в m учитывается раньше, чем число покидает путь, поэтому
+	// разность не меньше настоящего количества чисел в пути
+	if n := c.taken - c.m.Snapshot().OutputCount; n > c.peak {
+		c.peak = n
+	}
+	return c.taken, true
+}
+
+func TestConfigMaxInFlightCap(t *testing.T) {
+	const ceiling = 5
+	cfg := DefaultConfig()
+	cfg.Workers, cfg.Duration, cfg.InputBuffer, cfg.WorkerDelay = 8, 30*time.Millisecond, 32, 0
+	cfg.MaxInFlight = ceiling
+
+	var m Metrics
+	src := &countingSource{m: &m}
+	s, err := Run(context.Background(), cfg, WithMetrics(&m), WithInputSource(src),
+		// медленный потребитель копит числа в пути
+		WithSink(func(int64) { time.Sleep(100 * time.Microsecond) }))
+	if err != nil {
+		t.Fatal(err)
+	}
+	// поля src пишет только генератор, а Run дождался его
+	if src.peak == 0 || src.peak > ceiling {
+		t.Fatalf("наибольшее количество чисел в пути = %d, want от 1 до %d", src.peak, ceiling)
+	}
+	if err := CheckInvariants(s); err != nil {
+		t.Fatal(err)
+	}
+}
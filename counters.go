@@ -0,0 +1,140 @@
+package pipeline
+
+import (
+	"math"
+	"sync/atomic"
+)
+
+// cacheLine — размер кэш-линии процессора, на который выравниваются
+// счётчики, обновляемые из разных горутин.
+const cacheLine = 64
+
+// flowCounter — количество и сумма чисел, прошедших через одну точку
+// конвейера. Оба поля обновляет одна и та же сторона (генератор или
+// потребители), поэтому они лежат рядом, а дополнение до кэш-линии не
+// даёт соседнему flowCounter попасть в ту же линию: иначе генератор и
+// потребители постоянно сбрасывали бы друг другу кэш (false sharing).
+type flowCounter struct {
+	count    atomic.Int64
+	sum      atomic.Int64
+	sample   sampler // какие числа входят в сумму, см. WithSampledSums
+	saturate bool    // сумма упирается в границы int64, см. WithSaturatingSums
+	_        [cacheLine - 25]byte
+}
+
+// add учитывает число v. Вызывается на каждое число и не выделяет память.
+func (c *flowCounter) add(v int64) {
+	c.count.Add(1)
+	if !c.sample.keep(v) {
+		return
+	}
+	if !c.saturate {
+		c.sum.Add(v)
+		return
+	}
+	for {
+		old := c.sum.Load()
+		if c.sum.CompareAndSwap(old, satAdd(old, v)) {
+			return
+		}
+	}
+}
+
+// sampler отбирает числа, которые входят в суммы при WithSampledSums:
+// число попадает в выборку, если его MixHash меньше порога. Выбор зависит
+// только от значения, поэтому одно и то же число одинаково учитывается на
+// входе, на выходе и среди отброшенных. Нулевой sampler берёт все числа.
+type sampler uint64
+
+// newSampler возвращает sampler, берущий примерно долю rate чисел; rate
+// не из интервала (0, 1) означает все числа.
+func newSampler(rate float64) sampler {
+	if rate <= 0 || rate >= 1 {
+		return 0
+	}
+	return sampler(max(uint64(rate*(1<<64)), 1))
+}
+
+// keep сообщает, входит ли v в выборку.
+func (s sampler) keep(v int64) bool {
+	return s == 0 || MixHash(v) < uint64(s)
+}
+
+// satAdd возвращает a+b, а при переполнении — math.MaxInt64 или
+// math.MinInt64.
+func satAdd(a, b int64) int64 {
+	switch {
+	case b > 0 && a > math.MaxInt64-b:
+		return math.MaxInt64
+	case b < 0 && a < math.MinInt64-b:
+		return math.MinInt64
+	}
+	return a + b
+}
+
+// saturated сообщает, упёрлась ли сумма v в границу int64.
+func saturated(v int64) bool {
+	return v == math.MaxInt64 || v == math.MinInt64
+}
+
+// counters — счётчики входа и выхода одного запуска. Они живут отдельно от
+// Stats, чтобы горячие поля не делили кэш-линию с остальной статистикой;
+// в Stats значения переносятся снимком.
+type counters struct {
+	input  flowCounter
+	output flowCounter
+}
+
+// fill записывает текущие значения счётчиков в s.
+func (c *counters) fill(s *Stats) {
+	s.InputCount, s.InputSum = c.input.count.Load(), c.input.sum.Load()
+	s.OutputCount, s.OutputSum = c.output.count.Load(), c.output.sum.Load()
+}
+
+// flowGauge — количество чисел в пути: выданных генератором, но ещё не
+// дошедших до результирующего канала и не отброшенных. С пределом
+// WithMaxInFlight генератор ждёт, пока их станет меньше предела.
+type flowGauge struct {
+	n     atomic.Int64
+	limit int64
+	freed chan struct{} // сигнал генератору, что число вышло из пути
+}
+
+// newFlowGauge создаёт счётчик с пределом limit; limit меньше 1 — без предела.
+func newFlowGauge(limit int64) *flowGauge {
+	return &flowGauge{limit: limit, freed: make(chan struct{}, 1)}
+}
+
+// enter учитывает число, которое генератор сейчас отправит. Вызывается
+// до отправки, чтобы число, быстро прошедшее конвейер, не ушло из пути
+// раньше, чем вошло в него. Вызывается только из горутины генератора.
+func (g *flowGauge) enter() {
+	g.n.Add(1)
+}
+
+// unenter отменяет enter для числа, которое так и не было отправлено.
+func (g *flowGauge) unenter() {
+	g.n.Add(-1)
+}
+
+// wait ждёт, если предел достигнут, пока какое-нибудь число выйдет из
+// пути, или отмены done. Вызывается только из горутины генератора, после
+// отправки числа.
+func (g *flowGauge) wait(done <-chan struct{}) {
+	for g.limit > 0 && g.n.Load() >= g.limit {
+		select {
+		case <-done:
+			return
+		case <-g.freed:
+		}
+	}
+}
+
+// leave учитывает число, вышедшее из пути.
+func (g *flowGauge) leave() {
+	g.n.Add(-1)
+	select {
+	case g.freed <- struct{}{}:
+	default:
+	}
+}
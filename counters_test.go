@@ -0,0 +1,196 @@
+package pipeline
+
+import (
+	"context"
+	"math"
+	"math/big"
+	"sync"
+	"sync/atomic"
+	"testing"
+)
+
+// countWorkers — число горутин, одновременно считающих числа в
+// BenchmarkCountMutex и BenchmarkCountAtomic.
+const countWorkers = 64
+
+// paddedCounter — счётчик одного воркера, занимающий целую кэш-линию.
+type paddedCounter struct {
+	n atomic.Int64
+	_ [cacheLine - 8]byte
+}
+
+// benchCount запускает countWorkers горутин, которые вместе b.N раз
+// вызывают inc со своим номером, без пауз между вызовами.
+func benchCount(b *testing.B, inc func(id int)) {
+	var wg sync.WaitGroup
+	for id := range countWorkers {
+		n := b.N / countWorkers
+		if id < b.N%countWorkers {
+			n++
+		}
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for range n {
+				inc(id)
+			}
+		}()
+	}
+	wg.Wait()
+}
+
+// BenchmarkCountMutex — исходный подсчёт amounts: общий слайс под одним
+// мьютексом.
+func BenchmarkCountMutex(b *testing.B) {
+	var mu sync.Mutex
+	amounts := make([]int64, countWorkers)
+	b.ResetTimer()
+	benchCount(b, func(id int) {
+		mu.Lock()
+		amounts[id]++
+		mu.Unlock()
+	})
+	b.StopTimer()
+
+	var total int64
+	for _, v := range amounts {
+		total += v
+	}
+	if total != int64(b.N) {
+		b.Fatalf("насчитано %d, want %d", total, b.N)
+	}
+}
+
+// BenchmarkCountAtomic — подсчёт по шардам: у каждого воркера свой
+// атомарный счётчик в отдельной кэш-линии.
+func BenchmarkCountAtomic(b *testing.B) {
+	amounts := make([]paddedCounter, countWorkers)
+	b.ResetTimer()
+	benchCount(b, func(id int) {
+		amounts[id].n.Add(1)
+	})
+	b.StopTimer()
+
+	var total int64
+	for i := range amounts {
+		total += amounts[i].n.Load()
+	}
+	if total != int64(b.N) {
+		b.Fatalf("насчитано %d, want %d", total, b.N)
+	}
+}
+
+// BenchmarkGeneratorHotPath измеряет путь генерации: Generator
+// отправляет числа, а обратный вызов учитывает их в flowCounter.
+// Ни то, ни другое не должно выделять память на каждое число.
+func BenchmarkGeneratorHotPath(b *testing.B) {
+	var c counters
+	ctx, cancel := context.WithCancel(context.Background())
+	ch := make(chan int64)
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		Generator(ctx, ch, c.input.add)
+	}()
+
+	if allocs := testing.AllocsPerRun(1000, func() { <-ch }); allocs != 0 {
+		b.Fatalf("allocs/op = %v, want 0", allocs)
+	}
+
+	b.ReportAllocs()
+	b.ResetTimer()
+	for range b.N {
+		<-ch
+	}
+	b.StopTimer()
+	cancel()
+	for range ch {
+	}
+	<-done
+}
+
+func TestFlowCounterNoAllocs(t *testing.T) {
+	var c counters
+	c.output.saturate = true
+	c.output.sample = newSampler(0.5)
+	v := int64(1)
+	allocs := testing.AllocsPerRun(1000, func() {
+		c.input.add(v)
+		c.output.add(v)
+		v++
+	})
+	if allocs != 0 {
+		t.Fatalf("allocs/op = %v, want 0", allocs)
+	}
+}
+
+func TestBigSumsNearOverflow(t *testing.T) {
+	values := []int64{math.MaxInt64, math.MaxInt64, math.MaxInt64 - 1, 5}
+	want := new(big.Int)
+	for _, v := range values {
+		want.Add(want, big.NewInt(v))
+	}
+
+	s := NewPipeline(2, WithSource(values), WithBigSums()).Run(context.Background())
+	if s.BigInputSum == nil || s.BigInputSum.Cmp(want) != 0 {
+		t.Fatalf("BigInputSum = %v, want %v", s.BigInputSum, want)
+	}
+	if s.BigOutputSum == nil || s.BigOutputSum.Cmp(want) != 0 {
+		t.Fatalf("BigOutputSum = %v, want %v", s.BigOutputSum, want)
+	}
+	// int64-суммы переполнились, но одинаково на входе и выходе
+	if s.InputSum != s.OutputSum {
+		t.Fatalf("InputSum = %d, OutputSum = %d", s.InputSum, s.OutputSum)
+	}
+	if err := CheckInvariants(s); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestSaturatingSums(t *testing.T) {
+	tests := []struct {
+		values []int64
+		want   int64
+	}{
+		{[]int64{math.MaxInt64 - 2, 1, 5, 7}, math.MaxInt64},
+		{[]int64{math.MinInt64 + 2, -1, -5, -7}, math.MinInt64},
+		{[]int64{10, -3, 4}, 11},
+	}
+	for _, tt := range tests {
+		s := NewPipeline(3, WithSource(tt.values), WithSaturatingSums()).Run(context.Background())
+		if s.InputSum != tt.want || s.OutputSum != tt.want {
+			t.Fatalf("%v: InputSum = %d, OutputSum = %d, want %d", tt.values, s.InputSum, s.OutputSum, tt.want)
+		}
+		if !s.Saturating {
+			t.Fatalf("%v: Saturating = false", tt.values)
+		}
+		if err := CheckInvariants(s); err != nil {
+			t.Fatalf("%v: %v", tt.values, err)
+		}
+	}
+}
+
+func TestSampledSums(t *testing.T) {
+	values := make([]int64, 1000)
+	for i := range values {
+		values[i] = int64(i + 1)
+	}
+	const full = 500500
+
+	sampled := NewPipeline(3, WithSource(values), WithSampledSums(0.1)).Run(context.Background())
+	if err := CheckInvariants(sampled); err != nil {
+		t.Fatal(err)
+	}
+	if sampled.InputCount != 1000 || sampled.InputSum == 0 || sampled.InputSum >= full {
+		t.Fatalf("выборка: InputCount = %d, InputSum = %d", sampled.InputCount, sampled.InputSum)
+	}
+
+	// rate 1 выключает выборку, суммы полные
+	all := NewPipeline(3, WithSource(values), WithSampledSums(1)).Run(context.Background())
+	if err := CheckInvariants(all); err != nil {
+		t.Fatal(err)
+	}
+	if all.InputSum != full || all.OutputSum != full {
+		t.Fatalf("полная проверка: InputSum = %d, OutputSum = %d, want %d", all.InputSum, all.OutputSum, full)
+	}
+}
@@ -0,0 +1,41 @@
+package pipeline
+
+import (
+	"context"
+	"encoding/csv"
+	"io"
+	"strconv"
+)
+
+// WriteCSV записывает числа из in в w в формате CSV: сначала строку
+// заголовка header, затем по одному числу в строке. Запись заканчивается,
+// когда in закрывается; при отмене ctx уже прочитанные числа записываются
+// и возвращается ошибка контекста. Ошибка записи в w прерывает работу и
+// возвращается; после неё in больше не читается.
+func WriteCSV(ctx context.Context, in <-chan int64, w io.Writer, header string) error {
+	cw := csv.NewWriter(w)
+	if err := cw.Write([]string{header}); err != nil {
+		return err
+	}
+
+	row := make([]string, 1)
+	for {
+		select {
+		case <-ctx.Done():
+			cw.Flush()
+			if err := cw.Error(); err != nil {
+				return err
+			}
+			return ctx.Err()
+		case v, ok := <-in:
+			if !ok {
+				cw.Flush()
+				return cw.Error()
+			}
+			row[0] = strconv.FormatInt(v, 10)
+			if err := cw.Write(row); err != nil {
+				return err
+			}
+		}
+	}
+}
@@ -0,0 +1,32 @@
+package pipeline
+
+import (
+	"bytes"
+	"context"
+	"encoding/csv"
+	"slices"
+	"strconv"
+	"testing"
+)
+
+func TestWriteCSV(t *testing.T) {
+	want := []int64{3, -1, 0, 9000000000}
+	var buf bytes.Buffer
+	if err := WriteCSV(context.Background(), Replay(context.Background(), want), &buf, "value"); err != nil {
+		t.Fatal(err)
+	}
+
+	rows, err := csv.NewReader(&buf).ReadAll()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(rows) != len(want)+1 || !slices.Equal(rows[0], []string{"value"}) {
+		t.Fatalf("строки %v, want заголовок и %d чисел", rows, len(want))
+	}
+	for i, row := range rows[1:] {
+		v, err := strconv.ParseInt(row[0], 10, 64)
+		if err != nil || v != want[i] {
+			t.Fatalf("строка %d = %v, want %d", i+1, row, want[i])
+		}
+	}
+}
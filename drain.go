@@ -0,0 +1,83 @@
+package pipeline
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// WithDrainRate ограничивает скорость дообработки после остановки
+// генератора: числа, которые доходят до результирующего канала после
+// неё, передаются потребителям (WithSink, коллекторам и т.д.) не чаще
+// perSecond в секунду, а не всей пачкой сразу — для получателей, которые
+// не выдерживают всплеска при остановке. Чтобы дообработка не тянулась
+// бесконечно, через maxDrain после первого такого числа ограничение
+// снимается, и остаток уходит без пауз; maxDrain меньше или равное 0 —
+// без этого предела. perSecond меньше или равное 0 отключает опцию.
+//
+// Ждёт потребитель, поэтому воркеры, которым некуда отдать число, тоже
+// ждут. Когда истекает контекст воркеров, ограничение снимается, и
+// оставшиеся числа уходят без пауз. В RunFor он истекает вместе с
+// генератором, и числа из буфера общего канала всё равно попадают в
+// Stats.Dropped; чтобы они дообработались с ограниченной скоростью,
+// дайте воркерам время через RunPhases.
+func WithDrainRate(perSecond float64, maxDrain time.Duration) Option {
+	return func(p *Pipeline) {
+		p.drainRate = perSecond
+		p.maxDrain = maxDrain
+	}
+}
+
+// drainLimiter выдаёт потребителям разрешения на числа с интервалом
+// every, начиная с первого вызова wait, и до limit после него. Методы
+// безопасны для параллельного вызова и для nil-указателя, тогда ничего
+// не ждут.
+type drainLimiter struct {
+	every time.Duration
+	limit time.Duration
+	clock Clock
+
+	mu       sync.Mutex
+	deadline time.Time // когда снимается ограничение, нулевое — ещё не начато
+	next     time.Time // время следующего разрешения
+}
+
+// newDrainLimiter создаёт ограничитель на perSecond чисел в секунду по
+// часам clock или возвращает nil, если perSecond не положительно.
+func newDrainLimiter(perSecond float64, limit time.Duration, clock Clock) *drainLimiter {
+	if perSecond <= 0 {
+		return nil
+	}
+	return &drainLimiter{every: time.Duration(float64(time.Second) / perSecond), limit: limit, clock: clock}
+}
+
+// wait ждёт разрешения на очередное число или отмены ctx: после неё
+// ограничение больше не действует, и wait сразу возвращается.
+func (l *drainLimiter) wait(ctx context.Context) {
+	if l == nil || ctx.Err() != nil {
+		return
+	}
+	now := l.clock.Now()
+	l.mu.Lock()
+	if l.deadline.IsZero() {
+		l.deadline = now.Add(l.limit)
+		l.next = now
+	}
+	if l.limit > 0 && !now.Before(l.deadline) {
+		l.mu.Unlock()
+		return
+	}
+	at := l.next
+	if at.Before(now) {
+		at = now
+	}
+	l.next = at.Add(l.every)
+	if l.limit > 0 && at.After(l.deadline) {
+		at = l.deadline
+	}
+	l.mu.Unlock()
+
+	if d := at.Sub(now); d > 0 {
+		sleep(ctx, l.clock, d)
+	}
+}
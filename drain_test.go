@@ -0,0 +1,63 @@
+package pipeline
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+)
+
+// backlogPipeline возвращает конвейер, у которого к остановке генератора
+// в буфере общего канала скапливается backlog чисел: потребитель стоит
+// на первом числе, пока не закроют release.
+func backlogPipeline(backlog int, release <-chan struct{}, opts ...Option) *Pipeline {
+	var once sync.Once
+	sink := WithSink(func(int64) {
+		once.Do(func() { <-release })
+	})
+	return NewPipeline(1, append([]Option{WithInputBuffer(backlog), WithWorkerOptions(WithJitter(0, 0)), sink}, opts...)...)
+}
+
+func TestDrainRate(t *testing.T) {
+	release := make(chan struct{})
+	time.AfterFunc(30*time.Millisecond, func() { close(release) })
+	p := backlogPipeline(50, release, WithDrainRate(500, 0))
+
+	start := time.Now()
+	s, err := p.RunPhases(context.Background(), 20*time.Millisecond, 5*time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	elapsed := time.Since(start)
+
+	// после остановки числа уходят не чаще одного в 2 мс
+	want := time.Duration(s.OutputCount-1) * 2 * time.Millisecond
+	if elapsed < want {
+		t.Fatalf("дообработка %d чисел заняла %v, want не меньше %v", s.OutputCount, elapsed, want)
+	}
+	if s.Dropped != 0 {
+		t.Fatalf("Dropped = %d, want 0", s.Dropped)
+	}
+	if err := CheckInvariants(s); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestDrainRateStopsWithWorkers(t *testing.T) {
+	release := make(chan struct{})
+	time.AfterFunc(30*time.Millisecond, func() { close(release) })
+	// с 10 числами в секунду backlog дообрабатывался бы секунды
+	p := backlogPipeline(50, release, WithDrainRate(10, 0))
+
+	start := time.Now()
+	s, err := p.RunPhases(context.Background(), 20*time.Millisecond, 50*time.Millisecond)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Fatalf("ограничение не снялось с отменой контекста воркеров: %v", elapsed)
+	}
+	if err := CheckInvariants(s); err != nil {
+		t.Fatal(err)
+	}
+}
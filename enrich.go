@@ -0,0 +1,123 @@
+package pipeline
+
+import (
+	"context"
+	"time"
+)
+
+// Map применяет f к каждому значению из in и отправляет результат в
+// возвращаемый канал в том же порядке. Канал закрывается после закрытия
+// in или отмены ctx; значение, результат которого не успели принять до
+// отмены, теряется.
+func Map[T, U any](ctx context.Context, in <-chan T, f func(T) U) <-chan U {
+	out := make(chan U)
+
+	go func() {
+		defer close(out)
+
+		for {
+			var v T
+			select {
+			case <-ctx.Done():
+				return
+			case x, ok := <-in:
+				if !ok {
+					return
+				}
+				v = x
+			}
+
+			select {
+			case <-ctx.Done():
+				return
+			case out <- f(v):
+			}
+		}
+	}()
+
+	return out
+}
+
+// ValueRecord — число вместе с метаданными для передачи во внешние
+// системы. Имя Record уже занято стадией записи потока.
+type ValueRecord struct {
+	Value     int64
+	Timestamp time.Time // когда число прошло через Enrich
+	Worker    int       // номер воркера, из канала которого пришло число
+}
+
+// Enrich превращает числа из in, пришедшие от воркера с номером worker,
+// в ValueRecord с текущим временем. Построена на Map.
+func Enrich(ctx context.Context, in <-chan int64, worker int) <-chan ValueRecord {
+	return Map(ctx, in, func(v int64) ValueRecord {
+		return ValueRecord{Value: v, Timestamp: time.Now(), Worker: worker}
+	})
+}
+
+// Result — итог обработки одного числа воркером WorkerEnvelope: либо
+// результат Value, либо ошибка Err, вместе с номером воркера.
+type Result struct {
+	Value  int64 // результат обработки; при ошибке — исходное число
+	Err    error // ошибка fn или *PanicError, если fn запаниковала
+	Worker int   // номер воркера
+}
+
+// WorkerEnvelope — вариант Worker, который отдаёт успехи и ошибки в одном
+// канале: каждое прочитанное из in число обрабатывается fn, и в
+// возвращаемый канал уходит Result с номером воркера id. Паника fn
+// превращается в Err типа *PanicError. Пауз между числами нет. Канал
+// закрывается после закрытия in или отмены ctx; уже прочитанное число
+// передаётся в любом случае.
+func WorkerEnvelope(ctx context.Context, id int, in <-chan int64, fn func(int64) (int64, error)) <-chan Result {
+	out := make(chan Result)
+
+	go func() {
+		defer close(out)
+
+		for {
+			var v int64
+			select {
+			case <-ctx.Done():
+				return
+			case x, ok := <-in:
+				if !ok {
+					return
+				}
+				v = x
+			}
+
+			res, err := callSafe(fn, v)
+			if err != nil {
+				res = v
+			}
+			out <- Result{Value: res, Err: err, Worker: id}
+		}
+	}()
+
+	return out
+}
+
+// Tagged — число вместе с номером генератора, который его выдал, см.
+// GenerateTagged. Через обобщённые стадии (Map, FanIn, TeeN и т.д.)
+// номер доходит до получателя вместе с числом.
+type Tagged struct {
+	Source int   // номер генератора
+	Value  int64 // число
+}
+
+// CountBySource читает in до закрытия или отмены ctx и возвращает, сколько
+// чисел пришло от каждого генератора.
+func CountBySource(ctx context.Context, in <-chan Tagged) map[int]int64 {
+	counts := make(map[int]int64)
+	for {
+		select {
+		case <-ctx.Done():
+			return counts
+		case t, ok := <-in:
+			if !ok {
+				return counts
+			}
+			counts[t.Source]++
+		}
+	}
+}
@@ -0,0 +1,95 @@
+package pipeline
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestEnrich(t *testing.T) {
+	before := time.Now()
+	var got []ValueRecord
+	for r := range Enrich(context.Background(), Replay(context.Background(), []int64{5, 6, 7}), 3) {
+		got = append(got, r)
+	}
+	after := time.Now()
+
+	if len(got) != 3 {
+		t.Fatalf("записей %d, want 3", len(got))
+	}
+	for i, r := range got {
+		if r.Value != int64(5+i) || r.Worker != 3 {
+			t.Fatalf("запись %d = %+v, want Value %d, Worker 3", i, r, 5+i)
+		}
+		if r.Timestamp.Before(before) || r.Timestamp.After(after) {
+			t.Fatalf("запись %d: Timestamp %v вне [%v, %v]", i, r.Timestamp, before, after)
+		}
+	}
+}
+
+func TestWorkerEnvelope(t *testing.T) {
+	errOdd := errors.New("нечётное")
+	in := Replay(context.Background(), []int64{1, 2, 3, 4})
+	var got []Result
+	for r := range WorkerEnvelope(context.Background(), 7, in, func(v int64) (int64, error) {
+		if v == 3 {
+			panic("три")
+		}
+		if v%2 == 1 {
+			return 0, errOdd
+		}
+		return 10 * v, nil
+	}) {
+		got = append(got, r)
+	}
+
+	if len(got) != 4 {
+		t.Fatalf("результатов %d, want 4", len(got))
+	}
+	var pe *PanicError
+	checks := []struct {
+		value int64
+		err   func(error) bool
+	}{
+		{1, func(err error) bool { return errors.Is(err, errOdd) }},
+		{20, func(err error) bool { return err == nil }},
+		{3, func(err error) bool { return errors.As(err, &pe) && pe.Value == 3 }},
+		{40, func(err error) bool { return err == nil }},
+	}
+	for i, c := range checks {
+		r := got[i]
+		if r.Worker != 7 || r.Value != c.value || !c.err(r.Err) {
+			t.Fatalf("результат %d = %+v, want Value %d и Worker 7", i, r, c.value)
+		}
+	}
+}
+
+func TestCountBySourceTagged(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	// каждый генератор сам считает, сколько чисел отправил
+	sent := make([]int64, 2)
+	ins := make([]<-chan Tagged, len(sent))
+	for i := range ins {
+		ch := make(chan Tagged)
+		ins[i] = ch
+		go GenerateTagged(ctx, ch, i, func(Tagged) { sent[i]++ })
+	}
+	// номер источника проходит через обобщённые стадии вместе с числом
+	doubled := Map(context.Background(), FanIn(context.Background(), ins...), func(v Tagged) Tagged {
+		v.Value *= 2
+		return v
+	})
+	time.AfterFunc(10*time.Millisecond, cancel)
+
+	counts := CountBySource(context.Background(), doubled)
+	if len(counts) != 2 || counts[0] == 0 || counts[1] == 0 {
+		t.Fatalf("CountBySource = %v, want числа от обоих источников", counts)
+	}
+	// FanIn закрылся после остановки обоих генераторов, их счётчики готовы
+	for i, n := range sent {
+		if counts[i] != n {
+			t.Fatalf("от источника %d пришло %d, отправлено %d", i, counts[i], n)
+		}
+	}
+}
@@ -0,0 +1,78 @@
+package pipeline
+
+// Event — событие жизненного цикла запуска, см. Pipeline.Events.
+type Event interface {
+	event()
+}
+
+// GeneratorStarted — генератор начал выдавать числа.
+type GeneratorStarted struct{}
+
+// WorkerStarted — воркер с номером ID начал работу.
+type WorkerStarted struct {
+	ID int
+}
+
+// WorkerStopped — воркер с номером ID завершился.
+type WorkerStopped struct {
+	ID int
+}
+
+// ContextCancelled — отменён контекст генератора или воркеров; Cause —
+// причина отмены (context.Cause).
+type ContextCancelled struct {
+	Cause error
+}
+
+// Completed — запуск завершён; Stats — его итоговая статистика. Это
+// последнее событие, после него канал закрывается.
+type Completed struct {
+	Stats Stats
+}
+
+func (GeneratorStarted) event() {}
+func (WorkerStarted) event()    {}
+func (WorkerStopped) event()    {}
+func (ContextCancelled) event() {}
+func (Completed) event()        {}
+
+// Events возвращает канал событий следующего запуска. Канал забирается
+// в начале Run или Start, поэтому Events нужно вызвать до них: канал,
+// запрошенный во время запуска, получит события только следующего. События
+// отправляются по мере происходящего, последним — Completed, после чего
+// канал закрывается; для следующего запуска Events нужно вызвать снова.
+// События разных горутин не упорядочены между собой: например,
+// WorkerStopped воркера, заметившего отмену, может прийти раньше
+// ContextCancelled.
+// Отправка блокирует стадию, которая её делает, поэтому канал нужно
+// читать до закрытия, иначе запуск не завершится.
+func (p *Pipeline) Events() <-chan Event {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if p.events == nil {
+		p.events = make(chan Event)
+	}
+	return p.events
+}
+
+// takeEvents забирает канал событий для начинающегося запуска.
+func (p *Pipeline) takeEvents() chan Event {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	ch := p.events
+	p.events = nil
+	return ch
+}
+
+// eventSink — канал событий одного запуска. Методы безопасны для
+// nil-значения: тогда события никуда не отправляются.
+type eventSink chan Event
+
+// send отправляет событие ev.
+func (e eventSink) send(ev Event) {
+	if e != nil {
+		e <- ev
+	}
+}
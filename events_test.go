@@ -0,0 +1,109 @@
+package pipeline
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestEventsOrder(t *testing.T) {
+	const workers = 3
+	p := NewPipeline(workers)
+	events := p.Events()
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	done := make(chan Stats)
+	go func() {
+		done <- p.Run(ctx)
+	}()
+
+	var got []Event
+	for ev := range events {
+		got = append(got, ev)
+	}
+	s := <-done
+
+	// index возвращает номер первого события, для которого match истинно
+	index := func(match func(Event) bool) int {
+		for i, ev := range got {
+			if match(ev) {
+				return i
+			}
+		}
+		t.Fatalf("нужного события нет среди %v", got)
+		return -1
+	}
+	genStarted := index(func(ev Event) bool { _, ok := ev.(GeneratorStarted); return ok })
+	cancelled := index(func(ev Event) bool { _, ok := ev.(ContextCancelled); return ok })
+	completed := len(got) - 1
+	c, ok := got[completed].(Completed)
+	if !ok {
+		t.Fatalf("последнее событие %T, want Completed", got[completed])
+	}
+	if c.Stats.InputCount != s.InputCount || c.Stats.OutputCount != s.OutputCount {
+		t.Fatalf("Completed.Stats = %+v, want %+v", c.Stats, s)
+	}
+	if genStarted > cancelled {
+		t.Fatalf("GeneratorStarted после ContextCancelled: %v", got)
+	}
+
+	started := map[int]int{}
+	for i, ev := range got {
+		switch ev := ev.(type) {
+		case WorkerStarted:
+			started[ev.ID] = i
+		case WorkerStopped:
+			at, ok := started[ev.ID]
+			if !ok || at > i {
+				t.Fatalf("WorkerStopped{%d} раньше WorkerStarted: %v", ev.ID, got)
+			}
+		}
+	}
+	if len(started) != workers {
+		t.Fatalf("WorkerStarted у %d воркеров, want %d", len(started), workers)
+	}
+}
+
+func TestEventsRequestedMidRun(t *testing.T) {
+	p := NewPipeline(2)
+	if err := p.Start(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	deadline := time.Now().Add(time.Second)
+	for p.InFlight() == 0 {
+		if time.Now().After(deadline) {
+			t.Fatal("запуск не начал выдавать числа")
+		}
+		time.Sleep(time.Millisecond)
+	}
+
+	// текущий запуск канал уже не получит и завершится, хотя его не читают
+	events := p.Events()
+	p.Close()
+	p.Wait()
+	select {
+	case ev, ok := <-events:
+		t.Fatalf("событие %v (открыт: %v) от запуска, начатого до Events", ev, ok)
+	default:
+	}
+
+	// события приходят от следующего запуска
+	if err := p.Start(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	ev := <-events
+	if _, ok := ev.(GeneratorStarted); !ok {
+		if _, ok := ev.(WorkerStarted); !ok {
+			t.Fatalf("первое событие %T, want начало запуска", ev)
+		}
+	}
+	p.Close()
+	var last Event
+	for ev := range events {
+		last = ev
+	}
+	if _, ok := last.(Completed); !ok {
+		t.Fatalf("последнее событие %T, want Completed", last)
+	}
+	p.Wait()
+}
@@ -0,0 +1,53 @@
+package pipeline_test
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/Alex-Nosov-ITMO/go-project-sprint-9"
+)
+
+func ExampleRun() {
+	cfg := pipeline.DefaultConfig()
+	cfg.Duration = 50 * time.Millisecond
+
+	s, err := pipeline.Run(context.Background(), cfg)
+	if err != nil {
+		fmt.Println(err)
+		return
+	}
+	fmt.Println(s.InputCount == s.OutputCount, s.InputSum == s.OutputSum)
+	fmt.Println(pipeline.CheckInvariants(s))
+	// Output:
+	// true true
+	// <nil>
+}
+
+// Неполная пачка Batch отправляется по часам стадии; с ManualClock это
+// происходит ровно тогда, когда тест переводит часы.
+func ExampleBatch() {
+	clock := pipeline.NewManualClock(time.Unix(0, 0))
+	in := make(chan int64)
+	batches := pipeline.Batch(context.Background(), in, 3, time.Second, pipeline.StageClock(clock))
+
+	for v := int64(1); v <= 3; v++ {
+		in <- v
+	}
+	fmt.Println(<-batches)
+
+	in <- 4
+	in <- 5
+	clock.Advance(time.Second)
+	fmt.Println(<-batches)
+
+	in <- 6
+	close(in)
+	for b := range batches {
+		fmt.Println(b)
+	}
+	// Output:
+	// [1 2 3]
+	// [4 5]
+	// [6]
+}
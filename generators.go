@@ -0,0 +1,278 @@
+package pipeline
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"io"
+	"strconv"
+)
+
+// PausableGenerator работает как Generator, но его можно приостановить
+// через управляющий канал pause: true приостанавливает генерацию, false
+// возобновляет её с того же числа. Пока генератор на паузе, он ничего
+// не отправляет в ch, но по-прежнему завершается при отмене ctx.
+// Закрытие pause снимает паузу насовсем.
+func PausableGenerator(ctx context.Context, ch chan<- int64, fn func(int64), pause <-chan bool) {
+	defer close(ch)
+
+	if fn == nil {
+		fn = func(int64) {}
+	}
+
+	var n int64 = 1
+	paused := false
+	for {
+		// на паузе ветка отправки выключена nil-каналом
+		out := ch
+		if paused {
+			out = nil
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case p, ok := <-pause:
+			if !ok {
+				pause = nil
+				paused = false
+				continue
+			}
+			paused = p
+		case out <- n:
+			fn(n)
+			n++
+		}
+	}
+}
+
+// GenerateUntilSum работает как Generator, но прекращает генерацию,
+// если следующее число сделало бы сумму отправленных чисел больше maxSum,
+// и закрывает канал ch. Отмена ctx тоже останавливает генерацию.
+func GenerateUntilSum(ctx context.Context, ch chan<- int64, maxSum int64, fn func(int64)) {
+	defer close(ch)
+
+	if fn == nil {
+		fn = func(int64) {}
+	}
+
+	// sum не больше maxSum, поэтому при неотрицательном maxSum разность
+	// maxSum-sum не переполняется, а при отрицательном числа не нужны
+	var sum int64
+	for n := int64(1); maxSum >= 0 && n <= maxSum-sum; n++ {
+		select {
+		case <-ctx.Done():
+			return
+		case ch <- n:
+			fn(n)
+			sum += n
+		}
+	}
+}
+
+// GenerateDown работает как Generator, но отправляет числа по убыванию
+// от from до to включительно и закрывает ch. Если from меньше to, ничего
+// не отправляется и возвращается ошибка; при отмене ctx генерация
+// прекращается, и GenerateDown возвращает nil, как и после to.
+func GenerateDown(ctx context.Context, ch chan<- int64, from, to int64, fn func(int64)) error {
+	defer close(ch)
+
+	if from < to {
+		return fmt.Errorf("начало отсчёта меньше конца: %d < %d", from, to)
+	}
+	if fn == nil {
+		fn = func(int64) {}
+	}
+
+	// условие проверяется после отправки, чтобы to == math.MinInt64 не
+	// приводило к переполнению
+	for n := from; ; n-- {
+		select {
+		case <-ctx.Done():
+			return nil
+		case ch <- n:
+			fn(n)
+		}
+		if n == to {
+			return nil
+		}
+	}
+}
+
+// GenerateFromReader работает как Generator, но вместо последовательности
+// 1, 2, 3 и т.д. отправляет в ch числа, записанные в r через пробельные
+// символы, и закрывает ch, дочитав r до конца. Возвращает nil при
+// достижении конца r, ошибку контекста при отмене ctx, ошибку чтения r
+// или ошибку разбора с номером неверного числа; числа до неё уже
+// отправлены.
+func GenerateFromReader(ctx context.Context, r io.Reader, ch chan<- int64, fn func(int64)) error {
+	defer close(ch)
+
+	if fn == nil {
+		fn = func(int64) {}
+	}
+
+	sc := bufio.NewScanner(r)
+	sc.Split(bufio.ScanWords)
+	for i := 1; sc.Scan(); i++ {
+		n, err := strconv.ParseInt(sc.Text(), 10, 64)
+		if err != nil {
+			return fmt.Errorf("число %d: %w", i, err)
+		}
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case ch <- n:
+			fn(n)
+		}
+	}
+	return sc.Err()
+}
+
+// GenerateTagged работает как Generator, но отправляет в ch числа 1, 2, 3
+// и т.д. с номером генератора source, чтобы после слияния нескольких
+// генераторов (например, через FanIn) было видно, откуда пришло число.
+func GenerateTagged(ctx context.Context, ch chan<- Tagged, source int, fn func(Tagged)) {
+	defer close(ch)
+
+	if fn == nil {
+		fn = func(Tagged) {}
+	}
+
+	for n := int64(1); ; n++ {
+		t := Tagged{Source: source, Value: n}
+		select {
+		case <-ctx.Done():
+			return
+		case ch <- t:
+			fn(t)
+		}
+	}
+}
+
+// PriorityGenerator пересылает в ch числа из двух очередей, high и low,
+// и закрывает ch, когда закрыты обе или отменён ctx. Пока в high есть
+// число, оно всегда отправляется раньше числа из low; low читается,
+// только когда high пуста. Число, прочитанное из очереди, но не принятое
+// ch до отмены ctx, теряется. Возвращает, сколько чисел каждой очереди
+// было отправлено.
+func PriorityGenerator(ctx context.Context, ch chan<- int64, high, low <-chan int64, fn func(int64)) (highCount, lowCount int64) {
+	defer close(ch)
+
+	if fn == nil {
+		fn = func(int64) {}
+	}
+
+	for high != nil || low != nil {
+		var v int64
+		var fromHigh, ok bool
+		// сначала без ожидания проверяем high, и только если она пуста,
+		// ждём число из любой очереди
+		select {
+		case v, ok = <-high:
+			fromHigh = true
+		default:
+			select {
+			case <-ctx.Done():
+				return highCount, lowCount
+			case v, ok = <-high:
+				fromHigh = true
+			case v, ok = <-low:
+			}
+		}
+		if !ok {
+			// закрытая очередь выключается nil-каналом
+			if fromHigh {
+				high = nil
+			} else {
+				low = nil
+			}
+			continue
+		}
+
+		select {
+		case <-ctx.Done():
+			return highCount, lowCount
+		case ch <- v:
+			fn(v)
+			if fromHigh {
+				highCount++
+			} else {
+				lowCount++
+			}
+		}
+	}
+	return highCount, lowCount
+}
+
+// sourceOf возвращает функцию, выдающую по очереди числа values, а если
+// values равен nil — бесконечную последовательность 1, 2, 3 и т.д., как
+// у Generator. Второе значение false означает, что числа кончились.
+func sourceOf(values []int64) func() (int64, bool) {
+	if values == nil {
+		var n int64
+		return func() (int64, bool) {
+			n++
+			return n, true
+		}
+	}
+	var i int
+	return func() (int64, bool) {
+		if i == len(values) {
+			return 0, false
+		}
+		i++
+		return values[i-1], true
+	}
+}
+
+// generateFrom работает как Generator, но берёт числа из next и
+// закрывает ch, когда они кончаются. После отмены ctx next больше не
+// вызывается; число, которое уже взято из next, но так и не отправлено,
+// возвращается с ok, равным true, чтобы его можно было вернуть источнику.
+// emit вызывается для каждого числа до отправки в ch, а не после, как fn:
+// иначе воркер может получить и обработать число раньше, чем его учтут.
+// Для неотправленного числа вызывается unemit. nil emit и unemit ничего не
+// делают.
+func generateFrom(ctx context.Context, ch chan<- int64, next func() (int64, bool), emit, unemit, fn func(int64)) (unsent int64, ok bool) {
+	defer close(ch)
+
+	for ctx.Err() == nil {
+		n, ok := next()
+		if !ok {
+			return 0, false
+		}
+		if emit != nil {
+			emit(n)
+		}
+		select {
+		case <-ctx.Done():
+			if unemit != nil {
+				unemit(n)
+			}
+			return n, true
+		case ch <- n:
+			fn(n)
+		}
+	}
+	return 0, false
+}
+
+// takeN возвращает функцию, выдающую первые n чисел next. Когда n чисел
+// выдано, она вызывает onLimit и сообщает, что числа кончились. В счёт
+// идут только отправленные числа: untake возвращает в запас число, которое
+// было выдано, но так и не отправлено.
+func takeN(next func() (int64, bool), n int64, onLimit func()) (take func() (int64, bool), untake func()) {
+	take = func() (int64, bool) {
+		if n == 0 {
+			onLimit()
+			return 0, false
+		}
+		v, ok := next()
+		if ok {
+			n--
+		}
+		return v, ok
+	}
+	return take, func() { n++ }
+}
@@ -0,0 +1,217 @@
+package pipeline
+
+import (
+	"context"
+	"math"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestGenerateUntilSum(t *testing.T) {
+	tests := []struct {
+		maxSum int64
+		want   int64 // наибольшая сумма 1+2+...+k, не превышающая maxSum
+	}{
+		{math.MinInt64, 0},
+		{-1, 0},
+		{0, 0},
+		{1, 1},
+		{2, 1},
+		{10, 10},
+		{14, 10},
+		{15, 15},
+		{1000, 990},
+	}
+	for _, tt := range tests {
+		ch := make(chan int64)
+		var sum int64
+		go GenerateUntilSum(context.Background(), ch, tt.maxSum, func(v int64) {
+			sum += v
+		})
+		var got int64
+		for v := range ch {
+			got += v
+		}
+		if got != tt.want {
+			t.Errorf("GenerateUntilSum(%d): сумма %d, want %d", tt.maxSum, got, tt.want)
+		}
+		if sum != got {
+			t.Errorf("GenerateUntilSum(%d): fn насчитала %d, want %d", tt.maxSum, sum, got)
+		}
+	}
+}
+
+func TestGenerateUntilSumHugeLimit(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	ch := make(chan int64)
+	go GenerateUntilSum(ctx, ch, math.MaxInt64, nil)
+
+	var prev int64
+	for range 1000 {
+		v := <-ch
+		if v != prev+1 {
+			t.Fatalf("после %d пришло %d", prev, v)
+		}
+		prev = v
+	}
+	cancel()
+	for range ch {
+	}
+}
+
+func TestPausableGenerator(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	ch := make(chan int64)
+	pause := make(chan bool)
+	go PausableGenerator(ctx, ch, nil, pause)
+
+	for want := int64(1); want <= 3; want++ {
+		if v := <-ch; v != want {
+			t.Fatalf("пришло %d, want %d", v, want)
+		}
+	}
+	pause <- true
+	select {
+	case v := <-ch:
+		t.Fatalf("на паузе пришло %d", v)
+	case <-time.After(20 * time.Millisecond):
+	}
+	pause <- false
+	if v := <-ch; v != 4 {
+		t.Fatalf("после паузы пришло %d, want 4", v)
+	}
+	cancel()
+	for range ch {
+	}
+}
+
+func TestGenerateFromReader(t *testing.T) {
+	tests := []struct {
+		input   string
+		want    []int64
+		wantErr bool
+	}{
+		{"1 2\n3\t-4\n", []int64{1, 2, 3, -4}, false},
+		{"", nil, false},
+		{"5 x 6", []int64{5}, true},
+	}
+	for _, tt := range tests {
+		ch := make(chan int64)
+		errc := make(chan error, 1)
+		var sum int64
+		go func() {
+			errc <- GenerateFromReader(context.Background(), strings.NewReader(tt.input), ch, func(v int64) {
+				sum += v
+			})
+		}()
+		var got []int64
+		for v := range ch {
+			got = append(got, v)
+		}
+		err := <-errc
+		if !slices.Equal(got, tt.want) || (err != nil) != tt.wantErr {
+			t.Fatalf("GenerateFromReader(%q) = %v, %v, want %v, ошибка: %v", tt.input, got, err, tt.want, tt.wantErr)
+		}
+		var want int64
+		for _, v := range tt.want {
+			want += v
+		}
+		if sum != want {
+			t.Fatalf("GenerateFromReader(%q): fn насчитала %d, want %d", tt.input, sum, want)
+		}
+	}
+}
+
+func TestGenerateDown(t *testing.T) {
+	ch := make(chan int64)
+	var fired []int64
+	errc := make(chan error, 1)
+	go func() {
+		errc <- GenerateDown(context.Background(), ch, 5, 1, func(v int64) {
+			fired = append(fired, v)
+		})
+	}()
+	var got []int64
+	for v := range ch {
+		got = append(got, v)
+	}
+	if err := <-errc; err != nil {
+		t.Fatal(err)
+	}
+	want := []int64{5, 4, 3, 2, 1}
+	if !slices.Equal(got, want) || !slices.Equal(fired, want) {
+		t.Fatalf("отправлено %v, fn получила %v, want %v", got, fired, want)
+	}
+
+	ch = make(chan int64)
+	if err := GenerateDown(context.Background(), ch, 1, 5, nil); err == nil {
+		t.Fatal("GenerateDown(1, 5) без ошибки")
+	}
+	if _, ok := <-ch; ok {
+		t.Fatal("канал не закрыт после ошибки")
+	}
+}
+
+func TestPriorityGenerator(t *testing.T) {
+	// обе очереди заполнены заранее, значит, числа готовы в обеих сразу
+	high, low := make(chan int64, 3), make(chan int64, 4)
+	for _, v := range []int64{1, 2, 3} {
+		high <- v
+	}
+	for _, v := range []int64{10, 20, 30, 40} {
+		low <- v
+	}
+	close(high)
+	close(low)
+
+	ch := make(chan int64)
+	var fired int
+	type counts struct{ high, low int64 }
+	res := make(chan counts, 1)
+	go func() {
+		h, l := PriorityGenerator(context.Background(), ch, high, low, func(int64) { fired++ })
+		res <- counts{h, l}
+	}()
+	var got []int64
+	for v := range ch {
+		got = append(got, v)
+	}
+	if want := []int64{1, 2, 3, 10, 20, 30, 40}; !slices.Equal(got, want) {
+		t.Fatalf("отправлено %v, want %v", got, want)
+	}
+	if c := <-res; c.high != 3 || c.low != 4 || fired != 7 {
+		t.Fatalf("высокий %d, низкий %d, fn вызвана %d раз, want 3, 4, 7", c.high, c.low, fired)
+	}
+}
+
+func TestTakeNCountsDelivered(t *testing.T) {
+	var limits int
+	take, untake := takeN(sourceOf([]int64{1, 2, 3, 4, 5, 6}), 3, func() { limits++ })
+
+	var got []int64
+	for range 2 {
+		v, _ := take()
+		got = append(got, v)
+	}
+	// второе число не отправлено и не идёт в счёт
+	untake()
+	for {
+		v, ok := take()
+		if !ok {
+			break
+		}
+		got = append(got, v)
+	}
+	if want := []int64{1, 2, 3, 4}; !slices.Equal(got, want) || limits != 1 {
+		t.Fatalf("выдано %v, onLimit вызвана %d раз, want %v и 1", got, limits, want)
+	}
+
+	// кончившийся источник не расходует запас и не вызывает onLimit
+	take, _ = takeN(sourceOf([]int64{7}), 3, func() { limits++ })
+	take()
+	if _, ok := take(); ok || limits != 1 {
+		t.Fatalf("после конца источника ok = %v, onLimit вызвана %d раз", ok, limits)
+	}
+}
@@ -0,0 +1,3 @@
+module github.com/Alex-Nosov-ITMO/go-project-sprint-9
+
+go 1.24
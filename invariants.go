@@ -0,0 +1,86 @@
+package pipeline
+
+import (
+	"fmt"
+	"math/big"
+)
+
+// Verify выполняет исходную проверку main: количество и сумма чисел на
+// входе и выходе совпадают, а числа по каналам в сумме дают количество
+// сгенерированных. Возвращает ошибку с описанием первого нарушения или
+// nil. Проверка строгая — она не учитывает отброшенные фильтром,
+// потерянные при отмене и недоставленные числа, поэтому подходит для
+// конвейеров без таких стадий; для остальных есть CheckInvariants.
+func Verify(s Stats) error {
+	if s.InputCount != s.OutputCount {
+		return fmt.Errorf("количество чисел не равно: %d != %d", s.InputCount, s.OutputCount)
+	}
+	if !sumsEqual(s, s.InputSum, s.OutputSum) {
+		return fmt.Errorf("суммы чисел не равны: %d != %d", s.InputSum, s.OutputSum)
+	}
+	var perChannel int64
+	for _, v := range s.PerChannel {
+		perChannel += v
+	}
+	if perChannel != s.InputCount {
+		return fmt.Errorf("разделение чисел по каналам неверное: %d != %d", perChannel, s.InputCount)
+	}
+	return nil
+}
+
+// CheckInvariants проверяет согласованность статистики запуска: каждое
+// сгенерированное число либо дошло до результирующего канала, либо было
+// отброшено фильтром, либо учтено как потерянное при отмене, либо ушло
+// в очередь недоставленных, и числа правильно разделены по каналам.
+// Если воркеры меняли числа (Stats.Transformed), суммы не сравниваются.
+// Без этих стадий она совпадает с Verify. Возвращает ошибку с описанием
+// первого нарушения или nil.
+//
+// Функцию удобно вызывать из тестов собственных конвейеров, собранных
+// из Generator, Worker и других стадий.
+func CheckInvariants(s Stats) error {
+	// числа, отброшенные фильтром, потерянные при отмене или ушедшие
+	// в очередь недоставленных, не доходят до результирующего канала
+	outputSum := s.OutputSum + s.FilteredSum + s.DroppedSum + s.DeadLetteredSum
+	if s.Saturating {
+		outputSum = satAdd(satAdd(satAdd(s.OutputSum, s.FilteredSum), s.DroppedSum), s.DeadLetteredSum)
+	}
+	outputCount := s.OutputCount + s.Filtered + s.Dropped + s.DeadLettered
+	switch {
+	case s.Transformed:
+	case s.BigInputSum != nil && s.BigOutputSum != nil:
+		// точные суммы не переполняются, сравниваем их
+		out := new(big.Int).Add(s.BigOutputSum, big.NewInt(s.FilteredSum))
+		out.Add(out, big.NewInt(s.DroppedSum))
+		out.Add(out, big.NewInt(s.DeadLetteredSum))
+		if s.BigInputSum.Cmp(out) != 0 {
+			return fmt.Errorf("суммы чисел не равны: %s != %s", s.BigInputSum, out)
+		}
+	case !sumsEqual(s, s.InputSum, outputSum):
+		return fmt.Errorf("суммы чисел не равны: %d != %d", s.InputSum, outputSum)
+	}
+	if s.InputCount != outputCount {
+		return fmt.Errorf("количество чисел не равно: %d != %d", s.InputCount, outputCount)
+	}
+
+	inputCount := s.InputCount - s.Filtered - s.Dropped - s.DeadLettered
+	for _, v := range s.PerChannel {
+		inputCount -= v
+	}
+	if inputCount != 0 {
+		return fmt.Errorf("разделение чисел по каналам неверное")
+	}
+
+	if len(s.MissingSeqs) > 0 {
+		return fmt.Errorf("потеряны числа с номерами %v", s.MissingSeqs)
+	}
+	return nil
+}
+
+// sumsEqual сравнивает суммы входа in и выхода out. С насыщением
+// (Stats.Saturating) сумма, упёршаяся в границу int64, равна любой другой
+// насыщенной: точное значение за границей уже неизвестно, а при числах
+// разных знаков оно зависит и от порядка сложения.
+func sumsEqual(s Stats, in, out int64) bool {
+	return in == out || s.Saturating && saturated(in) && saturated(out)
+}
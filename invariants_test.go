@@ -0,0 +1,115 @@
+package pipeline
+
+import (
+	"context"
+	"errors"
+	"testing"
+)
+
+func TestCheckInvariants(t *testing.T) {
+	valid := Stats{
+		InputCount: 6, InputSum: 21,
+		OutputCount: 4, OutputSum: 10,
+		Filtered: 1, FilteredSum: 5,
+		Dropped: 1, DroppedSum: 6,
+		PerChannel: []int64{3, 1},
+	}
+	tests := []struct {
+		name    string
+		edit    func(s *Stats)
+		wantErr bool
+	}{
+		{"valid", func(*Stats) {}, false},
+		{"count", func(s *Stats) { s.OutputCount-- }, true},
+		{"sum", func(s *Stats) { s.OutputSum++ }, true},
+		{"per channel", func(s *Stats) { s.PerChannel[0]++ }, true},
+		{"missing", func(s *Stats) { s.MissingSeqs = []int64{3} }, true},
+		{"transformed sum", func(s *Stats) { s.Transformed, s.OutputSum = true, 20 }, false},
+		{"dead lettered", func(s *Stats) {
+			s.OutputCount, s.OutputSum = 3, 7
+			s.DeadLettered, s.DeadLetteredSum = 1, 3
+			s.PerChannel = []int64{2, 1}
+		}, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := valid
+			s.PerChannel = append([]int64(nil), valid.PerChannel...)
+			tt.edit(&s)
+			if err := CheckInvariants(s); (err != nil) != tt.wantErr {
+				t.Fatalf("CheckInvariants = %v, want ошибку: %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestCheckInvariantsRun(t *testing.T) {
+	s := NewPipeline(3, WithFilter(func(v int64) bool { return v%3 != 0 }), WithSource([]int64{1, 2, 3, 4, 5, 6})).Run(context.Background())
+	if err := CheckInvariants(s); err != nil {
+		t.Fatal(err)
+	}
+	if err := Verify(s); err == nil {
+		t.Fatal("Verify не заметил отброшенных фильтром чисел")
+	}
+}
+
+func TestVerify(t *testing.T) {
+	tests := []struct {
+		name    string
+		s       Stats
+		wantErr bool
+	}{
+		{"ok", Stats{InputCount: 3, InputSum: 6, OutputCount: 3, OutputSum: 6, PerChannel: []int64{1, 2}}, false},
+		{"count", Stats{InputCount: 3, InputSum: 6, OutputCount: 2, OutputSum: 6, PerChannel: []int64{1, 2}}, true},
+		{"sum", Stats{InputCount: 3, InputSum: 6, OutputCount: 3, OutputSum: 5, PerChannel: []int64{1, 2}}, true},
+		{"per channel", Stats{InputCount: 3, InputSum: 6, OutputCount: 3, OutputSum: 6, PerChannel: []int64{1, 1}}, true},
+		// Verify строже CheckInvariants и не учитывает отброшенные числа
+		{"filtered", Stats{InputCount: 3, InputSum: 6, OutputCount: 2, OutputSum: 4, Filtered: 1, FilteredSum: 2, PerChannel: []int64{1, 1}}, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := Verify(tt.s); (err != nil) != tt.wantErr {
+				t.Fatalf("Verify = %v, want ошибку: %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestStrictToggle(t *testing.T) {
+	// воркер с ошибкой без обработчика молча теряет число 2, и итог
+	// нарушает CheckInvariants
+	broken := func(strict bool) *Pipeline {
+		lose := WithProcessErr(func(v int64) (int64, error) {
+			if v == 2 {
+				return 0, errors.New("сбой")
+			}
+			return v, nil
+		}, nil)
+		return NewPipeline(2, WithSource([]int64{1, 2, 3}), WithWorkerOptions(lose), WithStrict(strict))
+	}
+
+	t.Run("strict", func(t *testing.T) {
+		defer func() {
+			if r := recover(); r == nil {
+				t.Fatal("нет паники при нарушении")
+			} else if _, ok := r.(error); !ok {
+				t.Fatalf("паника %v, want ошибку проверки", r)
+			}
+		}()
+		broken(true).Run(context.Background())
+	})
+
+	t.Run("not strict", func(t *testing.T) {
+		s := broken(false).Run(context.Background())
+		if s.Err == nil || s.Err.Error() != CheckInvariants(s).Error() {
+			t.Fatalf("Err = %v, want %v", s.Err, CheckInvariants(s))
+		}
+	})
+
+	t.Run("valid", func(t *testing.T) {
+		s := NewPipeline(2, WithSource([]int64{1, 2, 3}), WithStrict(true)).Run(context.Background())
+		if s.Err != nil {
+			t.Fatal(s.Err)
+		}
+	})
+}
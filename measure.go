@@ -0,0 +1,45 @@
+package pipeline
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// Measure запускает конвейер из numWorkers воркеров на время d и
+// возвращает устойчивую пропускную способность в числах в секунду
+// вместе со статистикой запуска.
+//
+// Окно измерения начинается с первого сгенерированного числа и
+// заканчивается остановкой генератора, поэтому запуск горутин и
+// дообработка чисел после остановки в него не входят.
+func Measure(ctx context.Context, numWorkers int, d time.Duration) (itemsPerSec float64, stats Stats) {
+	ctx, cancel := context.WithTimeout(ctx, d)
+	defer cancel()
+
+	var (
+		first, stop time.Time
+		once        sync.Once
+	)
+	p := NewPipeline(numWorkers)
+	p.onInput = func(int64) {
+		once.Do(func() {
+			first = time.Now()
+		})
+	}
+	stopped := make(chan struct{})
+	context.AfterFunc(ctx, func() {
+		stop = time.Now()
+		close(stopped)
+	})
+
+	stats = p.Run(ctx)
+	// Run возвращается только после отмены ctx
+	<-stopped
+
+	window := stop.Sub(first)
+	if first.IsZero() || window <= 0 {
+		return 0, stats
+	}
+	return float64(stats.InputCount) / window.Seconds(), stats
+}
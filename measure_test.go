@@ -0,0 +1,36 @@
+package pipeline
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+)
+
+// BenchmarkMeasure сравнивает пропускную способность конвейера при
+// разном числе воркеров. Каждая итерация — отдельный запуск Measure.
+func BenchmarkMeasure(b *testing.B) {
+	for _, workers := range []int{1, 4, 16, 64} {
+		b.Run(fmt.Sprintf("workers=%d", workers), func(b *testing.B) {
+			var total float64
+			for range b.N {
+				perSec, s := Measure(context.Background(), workers, 50*time.Millisecond)
+				if err := CheckInvariants(s); err != nil {
+					b.Fatal(err)
+				}
+				total += perSec
+			}
+			b.ReportMetric(total/float64(b.N), "items/s")
+		})
+	}
+}
+
+func TestMeasure(t *testing.T) {
+	perSec, s := Measure(context.Background(), 4, 50*time.Millisecond)
+	if err := CheckInvariants(s); err != nil {
+		t.Fatal(err)
+	}
+	if perSec <= 0 || s.InputCount == 0 {
+		t.Fatalf("Measure = %v чисел/с, InputCount = %d, want больше нуля", perSec, s.InputCount)
+	}
+}
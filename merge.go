@@ -0,0 +1,183 @@
+package pipeline
+
+import (
+	"context"
+	"reflect"
+	"sync"
+)
+
+// FanIn сливает каналы ins с любыми значениями в один, по горутине на
+// канал, как fan-in конвейера; порядок между каналами не сохраняется.
+// Возвращаемый канал закрывается, когда закрыты все ins или отменён ctx;
+// значение, не принятое до отмены, теряется.
+func FanIn[T any](ctx context.Context, ins ...<-chan T) <-chan T {
+	out := make(chan T)
+
+	var wg sync.WaitGroup
+	for _, in := range ins {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for {
+				select {
+				case <-ctx.Done():
+					return
+				case v, ok := <-in:
+					if !ok {
+						return
+					}
+					select {
+					case <-ctx.Done():
+						return
+					case out <- v:
+					}
+				}
+			}
+		}()
+	}
+	go func() {
+		wg.Wait()
+		close(out)
+	}()
+
+	return out
+}
+
+// MergeSorted сливает каналы ins в один упорядоченный по возрастанию поток.
+// Каждый канал из ins должен сам выдавать числа по неубыванию — например,
+// выходы воркеров при раздаче чисел по кругу из упорядоченного источника:
+// воркер не меняет порядок своих чисел. Если это условие нарушено, порядок
+// результата не гарантируется, но ни одно число не теряется.
+//
+// Перед каждой отправкой MergeSorted ждёт очередное число или закрытие
+// каждого ещё открытого канала, поэтому медленный канал задерживает весь
+// поток. Возвращаемый канал закрывается, когда закрыты все ins или
+// отменён контекст ctx.
+func MergeSorted(ctx context.Context, ins ...<-chan int64) <-chan int64 {
+	out := make(chan int64)
+
+	go func() {
+		defer close(out)
+
+		// heads[i] — очередное число из ins[i]; open[i] — канал ещё не закрыт.
+		heads := make([]int64, len(ins))
+		open := make([]bool, len(ins))
+		next := func(i int) bool {
+			select {
+			case <-ctx.Done():
+				return false
+			case v, ok := <-ins[i]:
+				heads[i], open[i] = v, ok
+				return true
+			}
+		}
+		for i := range ins {
+			if !next(i) {
+				return
+			}
+		}
+
+		for {
+			lo := -1
+			for i := range ins {
+				if open[i] && (lo < 0 || heads[i] < heads[lo]) {
+					lo = i
+				}
+			}
+			if lo < 0 {
+				return
+			}
+			select {
+			case <-ctx.Done():
+				return
+			case out <- heads[lo]:
+			}
+			if !next(lo) {
+				return
+			}
+		}
+	}()
+
+	return out
+}
+
+// FairFanIn сливает каналы ins в один, опрашивая их по кругу. Обычный
+// fan-in конвейера выделяет на каждый канал горутину и полагается на
+// планировщик, поэтому быстрый канал может надолго опередить медленный.
+// FairFanIn за один проход круга берёт из каждого готового канала не больше
+// одного числа: если в двух каналах числа есть всегда, они чередуются,
+// а число из канала, ставшего готовым, будет прочитано не позже чем через
+// len(ins)-1 чисел из остальных. Если ни один канал не готов, FairFanIn
+// ждёт первый готовый, и круг продолжается с канала, следующего за ним.
+//
+// Возвращаемый канал закрывается, когда закрыты все ins или отменён
+// контекст ctx.
+func FairFanIn(ctx context.Context, ins ...<-chan int64) <-chan int64 {
+	out := make(chan int64)
+
+	go func() {
+		defer close(out)
+
+		open := make([]bool, len(ins))
+		left := len(ins)
+		for i := range open {
+			open[i] = true
+		}
+		send := func(v int64) bool {
+			select {
+			case <-ctx.Done():
+				return false
+			case out <- v:
+				return true
+			}
+		}
+
+		for i := 0; left > 0; {
+			// проход круга без ожидания
+			got := false
+			for range ins {
+				if open[i] {
+					select {
+					case v, ok := <-ins[i]:
+						if !ok {
+							open[i] = false
+							left--
+						} else if !send(v) {
+							return
+						}
+						got = true
+					default:
+					}
+				}
+				i = (i + 1) % len(ins)
+			}
+			if got || left == 0 {
+				continue
+			}
+
+			// ни один канал не готов: ждём первый готовый
+			cases := []reflect.SelectCase{{Dir: reflect.SelectRecv, Chan: reflect.ValueOf(ctx.Done())}}
+			idx := []int{-1}
+			for j, in := range ins {
+				if open[j] {
+					cases = append(cases, reflect.SelectCase{Dir: reflect.SelectRecv, Chan: reflect.ValueOf(in)})
+					idx = append(idx, j)
+				}
+			}
+			chosen, v, ok := reflect.Select(cases)
+			if chosen == 0 {
+				return
+			}
+			j := idx[chosen]
+			if !ok {
+				open[j] = false
+				left--
+			} else if !send(v.Int()) {
+				return
+			}
+			i = (j + 1) % len(ins)
+		}
+	}()
+
+	return out
+}
@@ -0,0 +1,67 @@
+package pipeline
+
+import (
+	"context"
+	"slices"
+	"testing"
+	"time"
+)
+
+func TestMergeSorted(t *testing.T) {
+	parts := [][]int64{{1, 4, 7, 10}, {2, 5}, {}, {3, 3, 9, 11, 12}, {-5, 0}}
+	ins := make([]<-chan int64, len(parts))
+	var want []int64
+	for i, part := range parts {
+		ins[i] = Replay(context.Background(), part)
+		want = append(want, part...)
+	}
+	slices.Sort(want)
+
+	got := readAll(MergeSorted(context.Background(), ins...))
+	if !slices.IsSorted(got) || !slices.Equal(got, want) {
+		t.Fatalf("MergeSorted = %v, want %v", got, want)
+	}
+}
+
+func TestFairFanInSlowInput(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	fast, slow := make(chan int64), make(chan int64)
+	go func() {
+		defer close(fast)
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case fast <- 1:
+			}
+		}
+	}()
+	const slowCount = 50
+	go func() {
+		defer close(slow)
+		for range slowCount {
+			time.Sleep(time.Millisecond)
+			select {
+			case <-ctx.Done():
+				return
+			case slow <- 2:
+			}
+		}
+	}()
+
+	// если медленный вход голодает, запуск обрывается по времени
+	timer := time.AfterFunc(2*time.Second, cancel)
+	defer timer.Stop()
+	got := map[int64]int{}
+	for v := range FairFanIn(ctx, fast, slow) {
+		got[v]++
+		if got[2] == slowCount {
+			cancel()
+		}
+	}
+	cancel()
+	// все числа медленного входа дошли, хотя быстрый готов всегда
+	if got[2] != slowCount || got[1] == 0 {
+		t.Fatalf("получено %v, want %d двоек", got, slowCount)
+	}
+}
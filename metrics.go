@@ -0,0 +1,90 @@
+package pipeline
+
+import "sync/atomic"
+
+// Metrics — счётчики конвейера, которые можно читать и обнулять прямо во
+// время работы, см. WithMetrics. В отличие от Stats они не создаются
+// заново на каждый запуск: один Metrics копит числа всех запусков, пока
+// его не обнулят через Reset, поэтому долгий конвейер может отдавать
+// значения за интервал, а не итог с самого начала. Нулевое значение
+// готово к работе. Методы безопасны для nil-указателя: Snapshot тогда
+// возвращает пустую статистику, а остальные ничего не делают.
+type Metrics struct {
+	input        flowCounter
+	output       flowCounter
+	filtered     atomic.Int64
+	dropped      atomic.Int64
+	deadLettered atomic.Int64
+}
+
+// Snapshot возвращает текущие значения счётчиков в полях Stats:
+// InputCount, InputSum, OutputCount, OutputSum, Filtered, Dropped и
+// DeadLettered. Остальные поля пустые.
+func (m *Metrics) Snapshot() Stats {
+	if m == nil {
+		return Stats{}
+	}
+	return Stats{
+		InputCount:   m.input.count.Load(),
+		InputSum:     m.input.sum.Load(),
+		OutputCount:  m.output.count.Load(),
+		OutputSum:    m.output.sum.Load(),
+		Filtered:     m.filtered.Load(),
+		Dropped:      m.dropped.Load(),
+		DeadLettered: m.deadLettered.Load(),
+	}
+}
+
+// Reset обнуляет все счётчики. Его можно вызывать одновременно с работой
+// конвейера: каждый счётчик обнуляется атомарно, так что увеличение
+// никогда не теряется наполовину и не портит значение. На границе
+// интервала теряются только числа, учтённые между Snapshot и Reset, а
+// число, пришедшее во время Reset, может оказаться учтённым на входе в
+// одном интервале, а на выходе — в следующем.
+func (m *Metrics) Reset() {
+	if m == nil {
+		return
+	}
+	m.input.count.Store(0)
+	m.input.sum.Store(0)
+	m.output.count.Store(0)
+	m.output.sum.Store(0)
+	m.filtered.Store(0)
+	m.dropped.Store(0)
+	m.deadLettered.Store(0)
+}
+
+// addInput учитывает сгенерированное число v.
+func (m *Metrics) addInput(v int64) {
+	if m != nil {
+		m.input.add(v)
+	}
+}
+
+// addOutput учитывает число v результирующего канала.
+func (m *Metrics) addOutput(v int64) {
+	if m != nil {
+		m.output.add(v)
+	}
+}
+
+// addFiltered учитывает число, отброшенное фильтром.
+func (m *Metrics) addFiltered() {
+	if m != nil {
+		m.filtered.Add(1)
+	}
+}
+
+// addDropped учитывает число, не обработанное из-за остановки.
+func (m *Metrics) addDropped() {
+	if m != nil {
+		m.dropped.Add(1)
+	}
+}
+
+// addDeadLettered учитывает число, отправленное в очередь недоставленных.
+func (m *Metrics) addDeadLettered() {
+	if m != nil {
+		m.deadLettered.Add(1)
+	}
+}
@@ -0,0 +1,61 @@
+package pipeline
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestMetricsResetWhileCounting(t *testing.T) {
+	var m Metrics
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Millisecond)
+	defer cancel()
+
+	var wg sync.WaitGroup
+	for range 4 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for ctx.Err() == nil {
+				m.addInput(1)
+				m.addOutput(1)
+				m.addDropped()
+			}
+		}()
+	}
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		for ctx.Err() == nil {
+			m.Reset()
+			if s := m.Snapshot(); s.InputCount < 0 || s.InputSum < 0 {
+				t.Errorf("отрицательный снимок %+v", s)
+				return
+			}
+		}
+	}()
+	wg.Wait()
+
+	m.Reset()
+	if s := m.Snapshot(); s.InputCount != 0 || s.InputSum != 0 || s.OutputCount != 0 || s.Dropped != 0 {
+		t.Fatalf("после Reset снимок %+v, want пустой", s)
+	}
+
+	// то же во время настоящего запуска
+	p := NewPipeline(2, WithMetrics(&m))
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		p.RunFor(context.Background(), 20*time.Millisecond)
+	}()
+	for {
+		select {
+		case <-done:
+			return
+		default:
+			m.Reset()
+			m.Snapshot()
+		}
+	}
+}
@@ -0,0 +1,106 @@
+package pipeline
+
+import (
+	"context"
+	"sync"
+)
+
+// seqValue — число с порядковым номером для восстановления порядка.
+type seqValue struct {
+	seq int64
+	v   int64
+}
+
+// OrderedPool обрабатывает числа из in функцией process в n параллельных
+// воркерах (n меньше 1 считается равным 1) и отдаёт результаты в
+// возвращаемый канал в том же порядке, в каком числа пришли из in.
+//
+// Каждое число получает порядковый номер; результаты, обогнавшие
+// предшественников, ждут в буфере переупорядочивания, пока не будут
+// готовы все более ранние. Чтобы одно медленное число не заставило буфер
+// расти без предела, в обработке и в буфере вместе находится не больше
+// 2·n чисел: дальше OrderedPool перестаёт читать in, пока медленное
+// число не обработается.
+//
+// Канал закрывается после закрытия in и выдачи всех результатов или при
+// отмене ctx; при отмене ещё не выданные результаты отбрасываются.
+func OrderedPool(ctx context.Context, in <-chan int64, n int, process func(int64) int64) <-chan int64 {
+	n = max(n, 1)
+	out := make(chan int64)
+	jobs := make(chan seqValue)
+	results := make(chan seqValue)
+	// tokens ограничивает числа в обработке и в буфере переупорядочивания
+	tokens := make(chan struct{}, 2*n)
+
+	go func() {
+		defer close(jobs)
+
+		for seq := int64(0); ; seq++ {
+			select {
+			case <-ctx.Done():
+				return
+			case tokens <- struct{}{}:
+			}
+			var v int64
+			select {
+			case <-ctx.Done():
+				return
+			case x, ok := <-in:
+				if !ok {
+					return
+				}
+				v = x
+			}
+			jobs <- seqValue{seq: seq, v: v}
+		}
+	}()
+
+	var wg sync.WaitGroup
+	for range n {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := range jobs {
+				results <- seqValue{seq: j.seq, v: process(j.v)}
+			}
+		}()
+	}
+	go func() {
+		wg.Wait()
+		close(results)
+	}()
+
+	go func() {
+		defer close(out)
+
+		pending := make(map[int64]int64, 2*n)
+		var next int64
+		cancelled := false
+		for r := range results {
+			// после отмены results дочитывается, чтобы воркеры завершились
+			if cancelled {
+				continue
+			}
+			pending[r.seq] = r.v
+			for {
+				v, ok := pending[next]
+				if !ok {
+					break
+				}
+				select {
+				case <-ctx.Done():
+					cancelled = true
+				case out <- v:
+				}
+				if cancelled {
+					break
+				}
+				delete(pending, next)
+				next++
+				<-tokens
+			}
+		}
+	}()
+
+	return out
+}
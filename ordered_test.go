@@ -0,0 +1,33 @@
+package pipeline
+
+import (
+	"context"
+	"math/rand"
+	"slices"
+	"testing"
+	"time"
+)
+
+func TestOrderedPoolRandomDelay(t *testing.T) {
+	in := make([]int64, 200)
+	// задержки заданы заранее: process вызывается из разных горутин
+	delays := make(map[int64]time.Duration, len(in))
+	rnd := rand.New(rand.NewSource(1))
+	for i := range in {
+		in[i] = int64(i + 1)
+		delays[in[i]] = time.Duration(rnd.Intn(500)) * time.Microsecond
+	}
+
+	out := OrderedPool(context.Background(), Replay(context.Background(), in), 8, func(v int64) int64 {
+		time.Sleep(delays[v])
+		return -v
+	})
+	got := readAll(out)
+	want := make([]int64, len(in))
+	for i, v := range in {
+		want[i] = -v
+	}
+	if !slices.Equal(got, want) {
+		t.Fatalf("порядок нарушен: %v", got)
+	}
+}
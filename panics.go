@@ -0,0 +1,95 @@
+package pipeline
+
+import (
+	"context"
+	"fmt"
+	"runtime/debug"
+	"sync"
+)
+
+// PanicError — паника при обработке числа, перехваченная воркером или
+// стадией Transform.
+type PanicError struct {
+	Value int64 // число, на котором случилась паника
+	Panic any   // значение, переданное в panic
+}
+
+func (e *PanicError) Error() string {
+	return fmt.Sprintf("паника при обработке числа %d: %v", e.Value, e.Panic)
+}
+
+// callSafe вызывает fn(v) и превращает панику в *PanicError.
+func callSafe(fn func(int64) (int64, error), v int64) (res int64, err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			err = &PanicError{Value: v, Panic: r}
+		}
+	}()
+	return fn(v)
+}
+
+// RunPanicError — паника в одной из горутин конвейера, перехваченная
+// границей WithPanicRecovery.
+type RunPanicError struct {
+	Panic any    // значение, переданное в panic
+	Stack []byte // стек горутины в момент паники
+}
+
+func (e *RunPanicError) Error() string {
+	return fmt.Sprintf("паника в конвейере: %v\n%s", e.Panic, e.Stack)
+}
+
+// panicBoundary запоминает первую панику запуска и отменяет его контексты.
+// Тем же способом, через fail, запуск останавливается на первой ошибке
+// WithPoolProcessErr. Методы безопасны для nil-указателя: тогда паника не
+// перехватывается.
+type panicBoundary struct {
+	once   sync.Once
+	err    error
+	cancel []context.CancelCauseFunc
+}
+
+// catch перехватывает панику; вызывается только через defer.
+func (b *panicBoundary) catch() {
+	if b == nil {
+		return
+	}
+	if r := recover(); r != nil {
+		b.fail(&RunPanicError{Panic: r, Stack: debug.Stack()})
+	}
+}
+
+// fail запоминает err, если это первая ошибка запуска, и отменяет его
+// контексты с причиной err.
+func (b *panicBoundary) fail(err error) {
+	if b == nil {
+		return
+	}
+	b.once.Do(func() {
+		b.err = err
+		for _, cancel := range b.cancel {
+			cancel(err)
+		}
+	})
+}
+
+// call вызывает fn(v), перехватывая панику.
+func (b *panicBoundary) call(fn func(int64), v int64) {
+	defer b.catch()
+	fn(v)
+}
+
+// test вызывает pred(v), перехватывая панику; при панике возвращает false.
+func (b *panicBoundary) test(pred func(int64) bool, v int64) (ok bool) {
+	defer b.catch()
+	return pred(v)
+}
+
+// failure возвращает первую перехваченную панику или ошибку fail, а если
+// их не было — nil. Вызывается после завершения всех горутин запуска.
+func (b *panicBoundary) failure() error {
+	if b == nil {
+		return nil
+	}
+	return b.err
+}
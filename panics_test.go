@@ -0,0 +1,60 @@
+package pipeline
+
+import (
+	"context"
+	"errors"
+	"slices"
+	"testing"
+	"time"
+)
+
+func TestPoolProcessPanicDeadLettered(t *testing.T) {
+	dead := make(chan int64, 1)
+	values := []int64{1, 2, 3, 4, 5, 6}
+	s := NewPipeline(2, WithSource(values), WithDeadLetter(dead), WithPoolProcess(func(v int64) int64 {
+		if v == 4 {
+			panic("четыре")
+		}
+		return v
+	})).Run(context.Background())
+	close(dead)
+
+	if got := readAll(dead); !slices.Equal(got, []int64{4}) {
+		t.Fatalf("в dead %v, want [4]", got)
+	}
+	if s.PanicCount != 1 || s.DeadLettered != 1 || s.DeadLetteredSum != 4 {
+		t.Fatalf("PanicCount = %d, DeadLettered = %d, DeadLetteredSum = %d, want 1, 1, 4",
+			s.PanicCount, s.DeadLettered, s.DeadLetteredSum)
+	}
+	// остальные числа прошли
+	if s.OutputCount != int64(len(values))-1 || s.OutputSum != 17 {
+		t.Fatalf("OutputCount = %d, OutputSum = %d, want 5, 17", s.OutputCount, s.OutputSum)
+	}
+	if err := CheckInvariants(s); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestPanicRecoveryReturnsError(t *testing.T) {
+	boom := WithProcess(func(v int64) int64 {
+		if v == 3 {
+			panic("три")
+		}
+		return v
+	})
+	done := make(chan error)
+	go func() {
+		_, err := NewPipeline(2, WithPanicRecovery(), WithWorkerOptions(boom)).RunFor(context.Background(), time.Minute)
+		done <- err
+	}()
+
+	select {
+	case err := <-done:
+		var pe *RunPanicError
+		if !errors.As(err, &pe) || pe.Panic != "три" {
+			t.Fatalf("RunFor = %v, want *RunPanicError", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("RunFor не вернулся после паники")
+	}
+}
@@ -0,0 +1,1410 @@
+package pipeline
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"math/big"
+	"runtime"
+	"runtime/pprof"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// ErrRunning возвращается при попытке изменить конвейер во время запуска.
+var ErrRunning = errors.New("конвейер уже запущен")
+
+// ErrRunTimeout — причина остановки (Stats.StopReason) конвейера, время
+// работы которого, заданное в RunFor или RunPhases, истекло.
+var ErrRunTimeout = errors.New("время работы конвейера истекло")
+
+// ErrLimitReached — причина остановки конвейера, генератор которого
+// выдал заданное WithTake количество чисел.
+var ErrLimitReached = errors.New("достигнут предел количества чисел")
+
+// ErrClosed — причина остановки конвейера, запуск которого прервал Close.
+var ErrClosed = errors.New("конвейер закрыт")
+
+// Stats — итоговая статистика одного запуска конвейера.
+type Stats struct {
+	RunID string // идентификатор запуска, см. WithRunID
+
+	// Err — первая паника запуска (*RunPanicError), если задана опция
+	// WithPanicRecovery, первая ошибка WithPoolProcessErr, ошибка проверки
+	// Config в RunStream или нарушение CheckInvariants с опцией
+	// WithStrict(false).
+	Err error
+
+	// StopReason — причина остановки: context.Cause контекста генератора,
+	// а если он не был отменён — контекста воркеров. ErrRunTimeout
+	// означает, что истекло время RunFor, ErrLimitReached — что исчерпан
+	// WithTake, ErrClosed — что был вызван Close, *RunPanicError — панику,
+	// ошибка WithPoolProcessErr — сбой обработки (см. Err),
+	// context.Canceled — обычную отмену; причину, переданную в отмену
+	// context.WithCancelCause, можно проверить через errors.Is.
+	// nil — генератор завершился сам, например исчерпав WithSource.
+	StopReason error
+
+	InputCount  int64   // количество сгенерированных чисел
+	InputSum    int64   // сумма сгенерированных чисел
+	OutputCount int64   // количество чисел результирующего канала
+	OutputSum   int64   // сумма чисел результирующего канала
+	PerChannel  []int64 // сколько чисел прошло через каждый канал outs[i]
+	Transformed bool    // воркеры меняли числа (WithPoolProcess), суммы входа и выхода различаются
+	Filtered    int64   // количество чисел, отброшенных фильтром
+	FilteredSum int64   // сумма чисел, отброшенных фильтром
+	Dropped     int64   // количество чисел, не обработанных из-за отмены
+	DroppedSum  int64   // сумма чисел, не обработанных из-за отмены
+
+	PanicCount      int64 // сколько раз паниковала функция WithPoolProcess
+	Invalid         int64 // сколько чисел результирующего канала не прошли WithOutputValidation
+	DeadLettered    int64 // количество чисел, отправленных в очередь недоставленных
+	DeadLetteredSum int64 // сумма чисел, отправленных в очередь недоставленных
+
+	// BigInputSum и BigOutputSum — точные суммы сгенерированных чисел и
+	// чисел результирующего канала, если задана опция WithBigSums.
+	// InputSum и OutputSum при переполнении int64 искажаются, а эти нет.
+	BigInputSum  *big.Int
+	BigOutputSum *big.Int
+
+	// Saturating — суммы входа и выхода насыщаются вместо переполнения,
+	// см. WithSaturatingSums.
+	Saturating bool
+
+	// SampleRate — доля чисел, входящих в суммы, если задана опция
+	// WithSampledSums; 0 — в суммы входят все числа.
+	SampleRate float64
+
+	// Latency — задержка чисел в конвейере, если задана опция WithLatency.
+	Latency LatencyStats
+
+	// MissingSeqs — порядковые номера сгенерированных чисел, которые
+	// потерялись по дороге, если задана опция WithGapDetection. Номера
+	// отброшенных фильтром и учтённых в Dropped чисел сюда не входят.
+	MissingSeqs []int64
+
+	// ScaleEvents — изменения размера пула, если задана опция WithAutoscale
+	// или WithAutoWorkers.
+	ScaleEvents []ScaleEvent
+
+	// AutoWorkers — количество воркеров, выбранное WithAutoWorkers; 0 —
+	// опция не задана.
+	AutoWorkers int
+
+	// Workers — статистика по воркерам, если задана опция WithWorkerStats.
+	Workers []*WorkerStat
+}
+
+// Option настраивает Pipeline.
+type Option func(*Pipeline)
+
+// WithWorkerOptions передаёт опции opts каждому воркеру конвейера.
+func WithWorkerOptions(opts ...WorkerOption) Option {
+	return func(p *Pipeline) {
+		p.workerOpts = append(p.workerOpts, opts...)
+	}
+}
+
+// WithWorkerStats включает сбор статистики по каждому воркеру в Stats.Workers.
+func WithWorkerStats() Option {
+	return func(p *Pipeline) {
+		p.workerStats = true
+	}
+}
+
+// WithFilter пропускает к результирующему каналу только числа, для
+// которых pred возвращает true. Фильтр применяется к выходу каждого
+// воркера, отброшенные числа учитываются в Stats.Filtered и
+// Stats.FilteredSum, а Stats.PerChannel считает только прошедшие.
+func WithFilter(pred func(int64) bool) Option {
+	return func(p *Pipeline) {
+		p.filter = pred
+	}
+}
+
+// WithLatency включает измерение задержки каждого числа от генерации
+// до результирующего канала и её сводку в Stats.Latency.
+func WithLatency() Option {
+	return func(p *Pipeline) {
+		p.latency = true
+	}
+}
+
+// WithWarmup исключает из Stats.Latency первые k чисел каждого воркера:
+// пока воркер разогревается, на задержку влияют запуск горутин и холодные
+// кэши, и сводка перестаёт отражать установившийся режим. Такие числа
+// обрабатываются и учитываются в количествах и суммах как обычно. Без
+// WithLatency опция ничего не делает; k меньше 1 — без разогрева.
+func WithWarmup(k int) Option {
+	return func(p *Pipeline) {
+		p.warmup = int64(k)
+	}
+}
+
+// WithConsumers задаёт количество горутин, параллельно читающих
+// результирующий канал. По умолчанию читает одна горутина; несколько
+// нужны, если обработка результатов сама по себе занимает заметное время.
+func WithConsumers(n int) Option {
+	return func(p *Pipeline) {
+		p.consumers = n
+	}
+}
+
+// WithInputBuffer задаёт размер буфера общего канала, из которого воркеры
+// берут числа. По умолчанию канал не буферизован.
+func WithInputBuffer(n int) Option {
+	return func(p *Pipeline) {
+		p.inputBuffer = n
+	}
+}
+
+// WithOutputBuffer задаёт размер буфера результирующего канала. По
+// умолчанию он равен количеству воркеров, и этого хватает, пока
+// потребитель успевает за воркерами. Если результаты обрабатываются
+// неравномерно, разумно брать буфер в 2–4 раза больше числа воркеров:
+// это сглаживает всплески, а на корректность размер буфера не влияет.
+func WithOutputBuffer(n int) Option {
+	return func(p *Pipeline) {
+		p.outputBuffer = n
+	}
+}
+
+// WithWeights распределяет числа между воркерами пропорционально
+// weights вместо общего канала, из которого каждый воркер берёт числа
+// сам. Количество воркеров становится равным len(weights). Так можно
+// нагрузить сильнее воркеры, которые работают быстрее остальных.
+// Распределение жёсткое (см. WeightedFanOut): если воркер, чья очередь
+// получать число, отстал, ждут все, поэтому веса должны соответствовать
+// реальной скорости воркеров.
+// Число, которое распределитель успел прочитать, но не успел передать
+// воркеру до отмены, учитывается в Stats.Dropped.
+func WithWeights(weights ...int) Option {
+	return func(p *Pipeline) {
+		p.weights = weights
+	}
+}
+
+// WithHashPartition распределяет числа между воркерами по хешу вместо
+// общего канала: число v всегда попадает к воркеру hash(v) % n, где n —
+// количество воркеров (см. HashFanOut; nil hash — MixHash). Так воркер
+// может хранить состояние по ключу. Распределение жёсткое, как у
+// WithWeights, которую эта опция отменяет. Число, которое распределитель
+// не успел передать до отмены, учитывается в Stats.Dropped.
+func WithHashPartition(hash func(int64) uint64) Option {
+	return func(p *Pipeline) {
+		p.partition = true
+		p.hash = hash
+	}
+}
+
+// WithMaxInFlight ограничивает количество чисел в пути — выданных
+// генератором, но ещё не дошедших до результирующего канала и не
+// отброшенных. Когда их n, генератор ждёт, пока хотя бы одно число не
+// выйдет из пути, так что память конвейера не растёт, даже если
+// потребитель отстаёт. Текущее значение показывает InFlight. n меньше 1
+// снимает ограничение.
+func WithMaxInFlight(n int64) Option {
+	return func(p *Pipeline) {
+		p.maxInFlight = n
+	}
+}
+
+// WithGapDetection включает проверку пропусков: каждое сгенерированное
+// число получает порядковый номер, и номера чисел, не дошедших до
+// результирующего канала, перечисляются в Stats.MissingSeqs. Это более
+// строгая проверка, чем сравнение количества: она показывает, какие
+// именно числа потерялись.
+func WithGapDetection() Option {
+	return func(p *Pipeline) {
+		p.gaps = true
+	}
+}
+
+// WithPoolProcess задаёт обработку, которую воркеры применяют к каждому
+// числу перед отправкой дальше (см. WithProcess), так что работа идёт
+// прямо в пуле без отдельной стадии Transform. Поскольку числа меняются,
+// Stats.Transformed становится true, а CheckInvariants не сравнивает
+// суммы. WithLatency и WithGapDetection ищут числа по значению, поэтому
+// если fn даёт разным числам одинаковые результаты, задержки этих чисел
+// могут перепутаться между собой; пропуски считаются точно.
+func WithPoolProcess(fn func(int64) int64) Option {
+	return func(p *Pipeline) {
+		p.process = func(_ int, v int64) (int64, error) { return fn(v), nil }
+	}
+}
+
+// WithPoolProcessID работает как WithPoolProcess, но fn получает ещё и
+// номер воркера workerID — индекс его канала в Stats.PerChannel. Это
+// позволяет держать в fn состояние по воркерам или решать, что делать
+// с числом, в зависимости от воркера. Заменяет WithPoolProcess.
+func WithPoolProcessID(fn func(workerID int, v int64) int64) Option {
+	return func(p *Pipeline) {
+		p.process = func(id int, v int64) (int64, error) { return fn(id, v), nil }
+	}
+}
+
+// WithPoolProcessErr работает как WithPoolProcess, но fn может вернуть
+// ошибку, и первая же ошибка прерывает весь запуск: оба его контекста
+// отменяются с этой ошибкой как причиной, генератор останавливается, а
+// воркеры дообрабатывают уже взятые числа и завершаются. Ошибка
+// записывается в Stats.Err и Stats.StopReason, и RunFor возвращает её.
+// Число, на котором случилась ошибка, учитывается в Stats.DeadLettered,
+// как при панике (см. WithDeadLetter). Если fn ни разу не вернула
+// ошибку, запуск завершается как обычно. Заменяет WithPoolProcess.
+func WithPoolProcessErr(fn func(int64) (int64, error)) Option {
+	return func(p *Pipeline) {
+		p.process = func(_ int, v int64) (int64, error) { return fn(v) }
+	}
+}
+
+// WithDeadLetter задаёт канал для чисел, на которых запаниковала
+// функция WithPoolProcess. Паника перехватывается всегда: число
+// учитывается в Stats.DeadLettered, ошибка пишется в лог, а конвейер
+// продолжает работу. Если канал задан, число ещё и отправляется в ch;
+// отправка блокирует воркер, поэтому ch нужно читать во время запуска.
+// Туда же попадает число, на котором WithPoolProcessErr вернула ошибку.
+func WithDeadLetter(ch chan<- int64) Option {
+	return func(p *Pipeline) {
+		p.deadLetter = ch
+	}
+}
+
+// WithBigSums включает подсчёт сумм входа и выхода в big.Int (см.
+// Stats.BigInputSum), чтобы долгий запуск не давал неверный результат
+// из-за переполнения int64. Сложение big.Int под мьютексом заметно
+// дороже атомарного сложения int64 и ограничивает пропускную способность
+// генератора и потребителей, поэтому опция выключена по умолчанию.
+func WithBigSums() Option {
+	return func(p *Pipeline) {
+		p.bigSums = true
+	}
+}
+
+// WithSaturatingSums заменяет переполнение сумм входа и выхода на
+// насыщение: достигнув math.MaxInt64 (или math.MinInt64), сумма на нём и
+// остаётся, а Stats.Saturating становится true. Verify и CheckInvariants
+// считают две насыщенные суммы равными, так что проверка
+// остаётся осмысленной на очень долгих запусках без дорогого WithBigSums;
+// количества чисел при этом сверяются как обычно. Добавление идёт через
+// CompareAndSwap и чуть дороже обычного атомарного сложения.
+func WithSaturatingSums() Option {
+	return func(p *Pipeline) {
+		p.saturate = true
+	}
+}
+
+// WithSampledSums удешевляет сверку сумм на очень больших запусках:
+// количества по-прежнему считаются по всем числам, а в суммы (InputSum,
+// OutputSum, FilteredSum, DroppedSum, DeadLetteredSum и точные суммы
+// WithBigSums) входит только выборка — примерно доля rate чисел,
+// отобранных хешем значения. Выборка одна и та же на входе, выходе и среди
+// отброшенных, так что Verify и CheckInvariants сравнивают суммы как
+// обычно. rate не из интервала (0, 1) означает полную проверку.
+//
+// Проверка становится вероятностной: потерю числа по-прежнему выдаёт
+// несовпадение количеств, но подмена одного числа другим замечается,
+// только если хотя бы одно из них попало в выборку, то есть с
+// вероятностью около 2·rate. Повторяющиеся значения попадают в выборку
+// все вместе или не попадают вовсе.
+func WithSampledSums(rate float64) Option {
+	return func(p *Pipeline) {
+		p.sample = newSampler(rate)
+		p.sampleRate = rate
+		if p.sample == 0 {
+			p.sampleRate = 0
+		}
+	}
+}
+
+// WithOnDrop задаёт функцию, которая получает каждое число, учтённое в
+// Stats.Dropped: сгенерированное, но не доставленное из-за остановки —
+// например, чтобы записать его в лог. Числа, которые воркеры успели
+// взять, до результирующего канала доходят всегда, так что вместе с fn
+// запуск не теряет ни одного числа молча. fn может вызываться из разных
+// горутин одновременно.
+func WithOnDrop(fn func(int64)) Option {
+	return func(p *Pipeline) {
+		p.onDrop = fn
+	}
+}
+
+// WithMetrics дублирует счётчики входа, выхода, отброшенных фильтром,
+// потерянных при остановке и недоставленных чисел в m, который можно
+// читать и обнулять во время работы (см. Metrics.Reset), например чтобы
+// отдавать значения за интервал. Один m можно передать нескольким
+// конвейерам.
+func WithMetrics(m *Metrics) Option {
+	return func(p *Pipeline) {
+		p.metrics = m
+	}
+}
+
+// WithPipelineClock задаёт источник времени для всего, что в конвейере
+// ждёт по часам: пауз воркеров (как WithWorkerOptions(WithClock(c)),
+// но WithClock в WithWorkerOptions важнее), снимков WithProgressInterval,
+// контроллера WithAutoscale и пауз WithDrainRate. Сроки RunFor и
+// RunPhases по-прежнему отсчитываются обычным контекстом. По умолчанию
+// используется RealClock; ManualClock позволяет проверять эти стадии в
+// тестах без time.Sleep.
+func WithPipelineClock(c Clock) Option {
+	return func(p *Pipeline) {
+		p.clock = c
+	}
+}
+
+// WithLogger задаёт логгер для событий конвейера: старта, запуска и
+// остановки генератора и воркеров и завершения со статистикой. Все записи
+// запуска несут его run_id. По умолчанию события никуда не пишутся.
+func WithLogger(logger *slog.Logger) Option {
+	return func(p *Pipeline) {
+		p.logger = logger
+	}
+}
+
+// WithSink задаёт функцию, которая вызывается для каждого числа
+// результирующего канала в порядке чтения. При нескольких потребителях
+// (WithConsumers) fn вызывается из разных горутин одновременно.
+func WithSink(fn func(int64)) Option {
+	return func(p *Pipeline) {
+		p.sink = fn
+	}
+}
+
+// WithCollector передаёт каждое число результирующего канала в
+// коллекторы cols, например SumCollector и CountCollector; итог можно
+// узнать через их Result после запуска или прямо во время него.
+// Коллекторы не обнуляются между запусками и копят числа всех запусков.
+// Вызовы Add, как и WithSink, попадают под WithPanicRecovery.
+func WithCollector(cols ...Collector) Option {
+	return func(p *Pipeline) {
+		p.collectors = append(p.collectors, cols...)
+	}
+}
+
+// WithOutputValidation проверяет каждое число результирующего канала
+// функцией fn, чтобы заметить испорченные или вышедшие за границы данные.
+// Число, для которого fn вернула ошибку, не останавливает запуск: оно
+// учитывается в Stats.Invalid, а ошибка, если errs не nil, отправляется в
+// errs. Само число всё равно считается дошедшим и передаётся в WithSink и
+// коллекторы. Отправка в errs блокирует потребителя, поэтому errs нужно
+// читать во время запуска; errs не закрывается. При нескольких
+// потребителях fn вызывается из разных горутин одновременно.
+func WithOutputValidation(fn func(int64) error, errs chan<- error) Option {
+	return func(p *Pipeline) {
+		p.validate = fn
+		p.invalid = errs
+	}
+}
+
+// WithProgressInterval отправляет в ch снимок статистики каждые d, пока
+// идёт запуск: счётчики входа, выхода, отброшенных и недоставленных чисел
+// на момент снимка. Сводки, которые считаются только в конце (PerChannel,
+// Latency, MissingSeqs и т.д.), в снимках пустые. Если ch не читают,
+// следующий снимок ждёт, пока прочитают предыдущий; по завершении запуска
+// или отмене контекста воркеров отправка прекращается. ch не закрывается.
+func WithProgressInterval(d time.Duration, ch chan<- Stats) Option {
+	return func(p *Pipeline) {
+		p.progressEvery = d
+		p.progress = ch
+	}
+}
+
+// WithRecorder записывает каждое сгенерированное число в rec в порядке
+// генерации; в конце запуска запись сбрасывается через rec.Flush, ошибку
+// которой можно узнать, вызвав Flush ещё раз. Записанное потом можно
+// воспроизвести через ReplaySource и WithSource.
+func WithRecorder(rec *Recorder) Option {
+	return func(p *Pipeline) {
+		p.recorder = rec
+	}
+}
+
+// WithSource заменяет последовательность 1, 2, 3 и т.д. генератора на
+// числа values: они отправляются по порядку, а когда кончаются, общий
+// канал закрывается, и запуск завершается сам, дообработав всё выданное
+// (Stats.StopReason тогда равен nil). Отмена контекста по-прежнему
+// останавливает генерацию раньше. WithLatency и WithGapDetection ищут
+// числа по значению, поэтому задержки повторяющихся в values чисел могут
+// перепутаться между собой; пропуски считаются точно.
+func WithSource(values []int64) Option {
+	return func(p *Pipeline) {
+		if values == nil {
+			values = []int64{}
+		}
+		p.source = func() func() (int64, bool) { return sourceOf(values) }
+		p.unread = nil
+	}
+}
+
+// WithInputSource работает как WithSource, но берёт числа из src, пока
+// его Next их выдаёт. Next вызывается из горутины генератора. Источник
+// не перематывается: следующий запуск продолжит с того места, где
+// остановился предыдущий. Число, которое генератор уже взял из src, но
+// не успел отправить до остановки, не теряется: следующий запуск
+// начинается с него.
+func WithInputSource(src Source) Option {
+	return func(p *Pipeline) {
+		h := &heldSource{src: src}
+		p.source = func() func() (int64, bool) { return h.next }
+		p.unread = h.unread
+	}
+}
+
+// WithPanicRecovery ставит границу, превращающую панику в любой горутине
+// конвейера — генератора, воркеров, сборщика, а также в функциях
+// WithFilter и WithSink — в ошибку вместо падения программы. Первая
+// паника записывается в Stats.Err как *RunPanicError со стеком и отменяет
+// контексты запуска, так что он сворачивается, как при обычной отмене;
+// RunFor возвращает её как ошибку. Число, на котором запаниковал фильтр,
+// считается отброшенным. Паника WithPoolProcess перехватывается и без этой
+// опции (см. WithDeadLetter).
+//
+// Граница работает по возможности: горутина, упавшая посреди работы, не
+// закрывает свои каналы, и соседние стадии могут так и не дождаться её.
+// Надёжно перехватываются только паники в WithFilter и WithSink.
+func WithPanicRecovery() Option {
+	return func(p *Pipeline) {
+		p.recoverPanics = true
+	}
+}
+
+// WithTake ограничивает запуск n первыми числами генератора: отправив их,
+// генератор останавливается с причиной ErrLimitReached, а отправленные
+// числа дообрабатываются. В счёт идут только числа, действительно
+// отправленные воркерам: число, взятое из источника, но не отправленное
+// до отмены, не считается. n меньше 1 снимает ограничение.
+func WithTake(n int64) Option {
+	return func(p *Pipeline) {
+		p.take = n
+	}
+}
+
+// workersPerProc — сколько воркеров по умолчанию приходится на один
+// процессор (runtime.GOMAXPROCS), см. WithMaxWorkers.
+const workersPerProc = 256
+
+// WithMaxWorkers ограничивает количество воркеров, переданное в
+// NewPipeline, значением n: просьба о слишком большом пуле, например
+// 100 000 воркеров, иначе обернулась бы таким же количеством каналов и
+// горутин. Если предел срабатывает, это пишется в лог. По умолчанию предел
+// равен 256·GOMAXPROCS; n меньше 1 снимает его. Воркеры WithWeights и
+// WithAutoscale задаются явно и не ограничиваются.
+func WithMaxWorkers(n int) Option {
+	return func(p *Pipeline) {
+		p.maxWorkers = n
+	}
+}
+
+// WithStrict включает проверку CheckInvariants в конце каждого запуска.
+// При strict нарушение вызывает панику с ошибкой проверки — для тестов,
+// которым нужно упасть сразу, как исходный main с log.Fatalf. Без strict
+// ошибка записывается в Stats.Err (если там ещё нет паники) и
+// возвращается из RunFor. Без опции конвейер сам ничего не проверяет, и
+// ошибку можно получить, вызвав Verify или CheckInvariants.
+func WithStrict(strict bool) Option {
+	return func(p *Pipeline) {
+		p.invariants = true
+		p.strict = strict
+	}
+}
+
+// WithSeedBase задаёт начальное значение генераторов случайных пауз
+// WithJitter для всего пула: воркер номер i получает seed+i, так что
+// паузы разных воркеров различаются, а запуски с одинаковым seed
+// повторяются. По умолчанию seed равен 1. WithSeed, переданный через
+// WithWorkerOptions, важнее и даёт всем воркерам одно значение.
+func WithSeedBase(seed int64) Option {
+	return func(p *Pipeline) {
+		p.seed = seed
+	}
+}
+
+// deterministicBuffer — размер буфера общего канала в режиме WithDeterministic.
+const deterministicBuffer = 64
+
+// WithDeterministic включает воспроизводимый режим для отладки: один
+// воркер без пауз, один потребитель и буферизованный общий канал.
+// Числа приходят в результирующий канал (и в WithSink) ровно в порядке
+// генерации 1, 2, 3 и т.д. Режим отменяет WithConsumers и количество
+// воркеров, переданное в NewPipeline.
+func WithDeterministic() Option {
+	return func(p *Pipeline) {
+		p.deterministic = true
+	}
+}
+
+// Pipeline связывает Generator, пул воркеров и сборщик результатов:
+// генератор пишет числа в общий канал, воркеры разбирают их по своим
+// каналам, а те сливаются в один результирующий канал.
+//
+// Воркеры изолированы друг от друга: каждый сам забирает из общего канала
+// следующее число, только когда закончил с предыдущим, а число, на котором
+// обработка упала, уходит в очередь недоставленных (WithDeadLetter) и в
+// общий канал не возвращается. Поэтому медленный или раз за разом падающий
+// воркер задерживает лишь те числа, которые уже взял, а остальные воркеры
+// продолжают разбирать общий канал в своём темпе. Исключение — WithWeights:
+// там числа раздаёт распределитель, и занятый воркер задерживает его.
+type Pipeline struct {
+	workers       int
+	maxWorkers    int
+	consumers     int
+	inputBuffer   int
+	outputBuffer  int
+	workerOpts    []WorkerOption
+	seed          int64
+	workerStats   bool
+	weights       []int
+	partition     bool
+	hash          func(int64) uint64
+	autoscale     *Autoscale
+	autoWorkers   bool
+	process       func(workerID int, v int64) (int64, error)
+	deadLetter    chan<- int64
+	bigSums       bool
+	saturate      bool
+	sample        sampler
+	sampleRate    float64
+	filter        func(int64) bool
+	sink          func(int64)
+	collectors    []Collector
+	validate      func(int64) error
+	invalid       chan<- error
+	onDrop        func(int64)
+	metrics       *Metrics
+	logger        *slog.Logger
+	clock         Clock
+	latency       bool
+	warmup        int64
+	gaps          bool
+	deterministic bool
+	recorder      *Recorder
+	source        func() func() (int64, bool) // очередной источник чисел запуска, nil — 1, 2, 3 и т.д.
+	unread        func(int64)                 // возвращает источнику неотправленное число, nil — не нужно
+	take          int64
+	maxInFlight   int64
+	drainRate     float64
+	maxDrain      time.Duration
+	recoverPanics bool
+	invariants    bool
+	strict        bool
+	progressEvery time.Duration
+	progress      chan<- Stats
+	onInput       func(int64)    // вызывается для каждого сгенерированного числа
+	boundary      *panicBoundary // граница текущего запуска, см. WithPanicRecovery
+	runEvents     eventSink      // события текущего запуска, см. Events
+
+	mu      sync.Mutex              // защищает events, stop и started
+	events  chan Event              // канал для следующего запуска, см. Events
+	stop    context.CancelCauseFunc // останавливает текущий запуск, см. Close
+	started *startedRun             // последний запуск Start, см. Wait
+
+	wg      sync.WaitGroup            // горутины текущего запуска
+	active  atomic.Int64              // количество работающих горутин
+	running atomic.Bool               // идёт ли сейчас запуск
+	flow    atomic.Pointer[flowGauge] // числа в пути текущего запуска
+}
+
+// NewPipeline создаёт конвейер с workers воркерами. Значение меньше 1
+// считается равным 1, а больше предела WithMaxWorkers — пределу.
+//
+// Воркеров может быть сколько угодно больше, чем чисел успеет выдать
+// генератор: воркер без единого числа просто закрывает свой канал, когда
+// закрывается общий, поэтому даже очень короткий запуск завершается.
+func NewPipeline(workers int, opts ...Option) *Pipeline {
+	p := &Pipeline{
+		workers:    max(workers, 1),
+		maxWorkers: workersPerProc * runtime.GOMAXPROCS(0),
+		logger:     slog.New(slog.DiscardHandler),
+		clock:      RealClock,
+		seed:       defaultSeed,
+	}
+	for _, opt := range opts {
+		opt(p)
+	}
+	if p.clock != RealClock {
+		p.workerOpts = append([]WorkerOption{WithClock(p.clock)}, p.workerOpts...)
+	}
+	if p.maxWorkers > 0 && p.workers > p.maxWorkers {
+		p.logger.Warn("workers capped", slog.Int("requested", p.workers), slog.Int("workers", p.maxWorkers))
+		p.workers = p.maxWorkers
+	}
+	if p.partition {
+		p.weights = nil
+	}
+	if len(p.weights) > 0 {
+		p.workers = len(p.weights)
+	}
+	if p.autoscale != nil {
+		p.weights = nil
+		p.partition = false
+		p.workers = p.autoscale.Max
+		if p.inputBuffer == 0 {
+			p.inputBuffer = p.autoscale.Max
+		}
+	}
+	if p.deterministic {
+		p.weights = nil
+		p.partition = false
+		p.autoscale = nil
+		p.workers = 1
+		p.consumers = 1
+		p.inputBuffer = deterministicBuffer
+		p.workerOpts = append(p.workerOpts, WithJitter(0, 0))
+	}
+	return p
+}
+
+// Workers возвращает количество воркеров конвейера после всех опций:
+// с учётом WithMaxWorkers, WithWeights, WithAutoscale (там это Max) и
+// WithDeterministic.
+func (p *Pipeline) Workers() int {
+	return p.workers
+}
+
+// InFlight возвращает количество чисел в пути текущего запуска: выданных
+// генератором, но ещё не дошедших до результирующего канала и не
+// отброшенных. Без запуска оно равно нулю.
+func (p *Pipeline) InFlight() int64 {
+	if g := p.flow.Load(); g != nil {
+		return g.n.Load()
+	}
+	return 0
+}
+
+// ActiveGoroutines возвращает количество работающих горутин конвейера:
+// генератора, воркеров и горутин сбора результатов. После возврата из
+// Run оно равно нулю.
+func (p *Pipeline) ActiveGoroutines() int {
+	return int(p.active.Load())
+}
+
+// Reset подготавливает конвейер к следующему запуску с теми же
+// настройками. Каналы, контекст, счётчики и Stats и так создаются заново
+// в каждом запуске; Reset дополнительно сбрасывает счётчик горутин, их
+// группу ожидания и итог последнего Start, так что Wait до следующего
+// Start возвращает пустую статистику. Сохраняется то, что по своим
+// опциям копится между запусками: позиция источника WithInputSource,
+// Recorder, Metrics и коллекторы WithCollector, а также канал Events,
+// запрошенный для следующего запуска. Во время запуска Reset возвращает
+// ErrRunning.
+func (p *Pipeline) Reset() error {
+	// флаг запуска держится и во время сброса, чтобы запуск не начался
+	// посреди него
+	if !p.running.CompareAndSwap(false, true) {
+		return ErrRunning
+	}
+	defer p.running.Store(false)
+
+	p.mu.Lock()
+	p.started = nil
+	p.stop = nil
+	p.mu.Unlock()
+	p.active.Store(0)
+	p.wg = sync.WaitGroup{}
+	return nil
+}
+
+// goStage запускает f в отдельной горутине и учитывает её в
+// ActiveGoroutines. Счётчик увеличивается до старта горутины, чтобы
+// она не выпала из подсчёта.
+func (p *Pipeline) goStage(f func()) {
+	p.active.Add(1)
+	p.wg.Add(1)
+	b := p.boundary
+	go func() {
+		defer p.wg.Done()
+		defer p.active.Add(-1)
+		defer b.catch()
+		f()
+	}()
+}
+
+// goLabeled работает как goStage, но выполняет f с метками pprof labels,
+// например stage=worker,id=3: по ним горутины конвейера различимы в
+// профилях горутин и процессора. Горутины, запущенные из f, наследуют
+// метки.
+func (p *Pipeline) goLabeled(labels pprof.LabelSet, f func()) {
+	p.goStage(func() {
+		pprof.Do(context.Background(), labels, func(context.Context) {
+			f()
+		})
+	})
+}
+
+// Run запускает конвейер и ждёт, пока генератор остановится по ctx,
+// все сгенерированные числа дойдут до результирующего канала и
+// завершатся все горутины.
+func (p *Pipeline) Run(ctx context.Context) Stats {
+	return p.RunStages(ctx, ctx)
+}
+
+// startedRun — запуск, начатый Start.
+type startedRun struct {
+	done  chan struct{} // закрывается по завершении запуска
+	stats Stats         // итог запуска, можно читать после закрытия done
+}
+
+// Start запускает конвейер в фоне, как Run, и сразу возвращается; итог
+// запуска возвращает Wait. Остановить запуск можно отменой ctx или
+// через Close. Если конвейер уже выполняет запуск — начатый Start или
+// любой другой, — Start возвращает ErrRunning.
+func (p *Pipeline) Start(ctx context.Context) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if !p.running.CompareAndSwap(false, true) {
+		return ErrRunning
+	}
+	r := &startedRun{done: make(chan struct{})}
+	p.started = r
+	// Close может прийти раньше, чем RunStages запомнит свою функцию
+	// остановки, поэтому до тех пор Close отменяет контекст генератора,
+	// созданный здесь
+	genCtx, stop := context.WithCancelCause(ctx)
+	p.stop = stop
+	go func() {
+		defer close(r.done)
+		// запуск снимается с учёта раньше, чем Wait вернётся
+		defer p.running.Store(false)
+		defer stop(nil)
+		r.stats = p.runStages(genCtx, ctx)
+	}()
+	return nil
+}
+
+// Wait ждёт завершения запуска, начатого последним вызовом Start, и
+// возвращает его статистику; повторный Wait возвращает её же. Без Start
+// Wait сразу возвращает пустую статистику.
+func (p *Pipeline) Wait() Stats {
+	p.mu.Lock()
+	r := p.started
+	p.mu.Unlock()
+
+	if r == nil {
+		return Stats{}
+	}
+	<-r.done
+	return r.stats
+}
+
+// RunFor запускает конвейер на время d: по его истечении генератор
+// останавливается, а выданные числа дообрабатываются. Неположительное d
+// отклоняется с ошибкой; ошибкой возвращается и Stats.Err — паника,
+// перехваченная WithPanicRecovery, или первая ошибка WithPoolProcessErr.
+func (p *Pipeline) RunFor(ctx context.Context, d time.Duration) (Stats, error) {
+	return p.RunPhases(ctx, d, 0)
+}
+
+// RunPhases работает как RunFor, но даёт воркерам ещё drain на
+// дообработку: генератор останавливается через gen, а контекст воркеров
+// отменяется только через gen+drain. Так числа, которые к концу генерации
+// лежат в буфере общего канала (WithInputBuffer) или у распределителя,
+// успевают дойти до результирующего канала, а не попадают в
+// Stats.Dropped. Отмена ctx останавливает обе фазы сразу. Неположительное
+// gen и отрицательное drain отклоняются с ошибкой.
+func (p *Pipeline) RunPhases(ctx context.Context, gen, drain time.Duration) (Stats, error) {
+	if gen <= 0 {
+		return Stats{}, fmt.Errorf("длительность должна быть положительной: %v", gen)
+	}
+	if drain < 0 {
+		return Stats{}, fmt.Errorf("время дообработки не может быть отрицательным: %v", drain)
+	}
+
+	// 3. Создание контекста: генератор — дочерний контекст воркеров,
+	// чтобы он тоже останавливался, когда истекает время дообработки
+	workCtx, cancelWork := context.WithTimeoutCause(ctx, gen+drain, ErrRunTimeout)
+	defer cancelWork()
+	genCtx, cancelGen := context.WithTimeoutCause(workCtx, gen, ErrRunTimeout)
+	defer cancelGen()
+
+	s := p.RunStages(genCtx, workCtx)
+	return s, s.Err
+}
+
+// RunStages запускает конвейер, в котором генератор управляется
+// контекстом genCtx, а пул воркеров — контекстом workCtx.
+//
+// Отмена genCtx останавливает генератор и закрывает общий канал;
+// воркеры обрабатывают всё, что успели прочитать, и завершаются.
+// Отмена workCtx останавливает воркеры; как только все они завершились,
+// генератор тоже останавливается, потому что его числа больше некому
+// читать. В обоих случаях каждое учтённое генератором число доходит до
+// результирующего канала. Исключение — числа, которые воркеры не успели
+// забрать из буфера общего канала или у распределителя WithWeights до
+// своей остановки: они учитываются в Stats.Dropped.
+//
+// Один Pipeline выполняет только один запуск за раз: если запуск уже
+// идёт (Run, RunFor, Start и т.д.), RunStages сразу возвращает Stats, у
+// которой Err и StopReason равны ErrRunning.
+func (p *Pipeline) RunStages(genCtx, workCtx context.Context) Stats {
+	if !p.running.CompareAndSwap(false, true) {
+		return Stats{Err: ErrRunning, StopReason: ErrRunning}
+	}
+	defer p.running.Store(false)
+	return p.runStages(genCtx, workCtx)
+}
+
+// runStages выполняет запуск RunStages; вызывающий уже отметил его в
+// p.running.
+func (p *Pipeline) runStages(genCtx, workCtx context.Context) Stats {
+	var s Stats
+	var c counters
+	c.input.saturate, c.output.saturate = p.saturate, p.saturate
+	s.Saturating = p.saturate
+	c.input.sample, c.output.sample = p.sample, p.sample
+	s.SampleRate = p.sampleRate
+
+	// генератор дополнительно останавливается, когда завершились воркеры
+	genCtx, stopGenCause := context.WithCancelCause(genCtx)
+	stopGen := func() { stopGenCause(errWorkersDone) }
+	defer stopGen()
+	p.setStop(stopGenCause)
+	defer p.setStop(nil)
+
+	if p.recoverPanics {
+		var cancelWork context.CancelCauseFunc
+		workCtx, cancelWork = context.WithCancelCause(workCtx)
+		defer cancelWork(nil)
+		p.boundary = &panicBoundary{cancel: []context.CancelCauseFunc{stopGenCause, cancelWork}}
+		defer func() { p.boundary = nil }()
+	}
+	b := p.boundary
+
+	// первая ошибка WithPoolProcessErr отменяет оба контекста запуска
+	var abort *panicBoundary
+	if p.process != nil {
+		var cancelWork context.CancelCauseFunc
+		workCtx, cancelWork = context.WithCancelCause(workCtx)
+		defer cancelWork(nil)
+		abort = &panicBoundary{cancel: []context.CancelCauseFunc{stopGenCause, cancelWork}}
+	}
+
+	events := eventSink(p.takeEvents())
+	p.runEvents = events
+	defer func() { p.runEvents = nil }()
+
+	// идентификатор запуска берётся из контекста генератора, а если его
+	// там нет — создаётся новый и передаётся воркерам
+	id, ok := RunIDFrom(genCtx)
+	if !ok {
+		id = newRunID()
+	}
+	workCtx = WithRunID(workCtx, id)
+	s.RunID = id
+	logger := p.logger.With(slog.String("run_id", id))
+
+	logger.Info("pipeline started", slog.Int("workers", p.workers))
+
+	flow := newFlowGauge(p.maxInFlight)
+	p.flow.Store(flow)
+	defer p.flow.Store(nil)
+
+	var tr *flightTracker
+	if p.latency || p.gaps {
+		tr = newFlightTracker(p.process != nil)
+	}
+
+	chIn := make(chan int64, p.inputBuffer)
+	genDone := make(chan struct{})
+	var bigIn, bigOut *bigSum
+	if p.bigSums {
+		bigIn, bigOut = new(bigSum), new(bigSum)
+	}
+
+	next := sourceOf(nil)
+	if p.source != nil {
+		next = p.source()
+	}
+	untake := func() {}
+	if p.take > 0 {
+		next, untake = takeN(next, p.take, func() { stopGenCause(ErrLimitReached) })
+	}
+	// число учитывается в пути и в трекере до отправки
+	emit := func(v int64) {
+		flow.enter()
+		tr.emit(v)
+	}
+	unemit := func(v int64) {
+		flow.unenter()
+		tr.unemit(v)
+		untake()
+	}
+
+	// генерируем числа, считая параллельно их количество и сумму
+	p.goLabeled(pprof.Labels("stage", "generator"), func() {
+		defer close(genDone)
+		logger.Debug("generator started")
+		defer logger.Debug("generator stopped")
+		events.send(GeneratorStarted{})
+		v, unsent := generateFrom(genCtx, chIn, next, emit, unemit, func(i int64) {
+			c.input.add(i)
+			p.metrics.addInput(i)
+			flow.wait(genCtx.Done())
+			if p.sample.keep(i) {
+				bigIn.add(i)
+			}
+			if p.recorder != nil {
+				p.recorder.Add(i)
+			}
+			if p.onInput != nil {
+				p.onInput(i)
+			}
+		})
+		if unsent && p.unread != nil {
+			p.unread(v)
+		}
+	})
+
+	// deadLetter учитывает число, обработка которого не удалась
+	deadLetter := func(v int64) {
+		atomic.AddInt64(&s.DeadLettered, 1)
+		p.metrics.addDeadLettered()
+		flow.leave()
+		if p.sample.keep(v) {
+			atomic.AddInt64(&s.DeadLetteredSum, v)
+		}
+		tr.dropInput(v)
+		if p.deadLetter != nil {
+			p.deadLetter <- v
+		}
+	}
+	onError := func(v int64, err error) {
+		logger.Error("process failed", slog.Int64("value", v), slog.Any("error", err))
+		abort.fail(err)
+		deadLetter(v)
+	}
+	base := p.workerOpts
+	if p.process != nil {
+		s.Transformed = true
+		base = append(base[:len(base):len(base)], WithPanicHandler(func(v int64, err error) {
+			atomic.AddInt64(&s.PanicCount, 1)
+			logger.Error("process panicked", slog.Int64("value", v), slog.Any("error", err))
+			deadLetter(v)
+		}))
+	}
+	// warm — сколько чисел взял каждый воркер, пока идёт разогрев WithWarmup
+	var warm []atomic.Int64
+	if p.latency && p.warmup > 0 {
+		warm = make([]atomic.Int64, p.workers)
+	}
+	// opts возвращает опции воркера с номером id
+	opts := func(id int) []WorkerOption {
+		// свой seed у каждого воркера, чтобы паузы WithJitter не шли в ногу;
+		// WithSeed из WithWorkerOptions идёт позже и важнее
+		opts := append([]WorkerOption{WithSeed(p.seed + int64(id))}, base...)
+		if warm != nil {
+			opts = append(opts[:len(opts):len(opts)], withReceive(func(v int64) {
+				if warm[id].Add(1) <= p.warmup {
+					tr.warmUp(v)
+				}
+			}))
+		}
+		if p.process == nil {
+			return opts
+		}
+		return append(opts[:len(opts):len(opts)], WithProcessErr(func(v int64) (int64, error) {
+			res, err := p.process(id, v)
+			if err == nil {
+				tr.rekey(v, res)
+			}
+			return res, err
+		}, onError))
+	}
+
+	// finished закрывается, когда результирующий канал прочитан до конца
+	finished := make(chan struct{})
+	if p.progress != nil && p.progressEvery > 0 {
+		p.goStage(func() {
+			p.reportProgress(workCtx, finished, &s, &c)
+		})
+	}
+	if events != nil {
+		p.goStage(func() {
+			select {
+			case <-genCtx.Done():
+				if cause := context.Cause(genCtx); cause != errWorkersDone {
+					events.send(ContextCancelled{Cause: cause})
+				}
+			case <-workCtx.Done():
+				events.send(ContextCancelled{Cause: context.Cause(workCtx)})
+			case <-finished:
+			}
+		})
+	}
+
+	var outs []chan int64
+	var workers []*WorkerStat
+	if p.autoscale != nil {
+		outs, workers = p.autoscaleOuts(workCtx, chIn, genDone, opts, &s, logger)
+	} else {
+		ins := p.workerInputs(workCtx, chIn, &s, tr)
+		outs, workers = p.fanOut(workCtx, ins, opts, p.workerStats, logger)
+	}
+	if p.filter != nil {
+		outs = p.filterOuts(outs, &s, tr, b)
+	}
+	chOut, amounts := p.fanIn(outs)
+
+	// 5. Читаем числа из результирующего канала
+	drain := newDrainLimiter(p.drainRate, p.maxDrain, p.clock)
+	var consumers sync.WaitGroup
+	for i := range max(p.consumers, 1) {
+		consumers.Add(1)
+		p.goLabeled(pprof.Labels("stage", "consumer", "id", strconv.Itoa(i)), func() {
+			defer consumers.Done()
+			for v := range chOut {
+				if drain != nil {
+					select {
+					case <-genCtx.Done():
+						drain.wait(workCtx)
+					default:
+					}
+				}
+				c.output.add(v)
+				p.metrics.addOutput(v)
+				flow.leave()
+				if p.sample.keep(v) {
+					bigOut.add(v)
+				}
+				tr.arrive(v)
+				if p.validate != nil {
+					if err := p.validate(v); err != nil {
+						atomic.AddInt64(&s.Invalid, 1)
+						if p.invalid != nil {
+							p.invalid <- err
+						}
+					}
+				}
+				if p.sink != nil {
+					b.call(p.sink, v)
+				}
+				for _, col := range p.collectors {
+					b.call(col.Add, v)
+				}
+			}
+		})
+	}
+	consumers.Wait()
+	close(finished)
+	// результирующий канал закрыт, значит воркеры уже не читают chIn
+	stopGen()
+	// если воркеры остановились по отмене раньше, чем разобрали буфер
+	// общего канала, оставшиеся в нём числа уже не будут обработаны;
+	// читать их можно только после остановки генератора, иначе чтение
+	// само заберёт у него новые числа
+	<-genDone
+	for v := range chIn {
+		p.drop(&s, tr, v)
+	}
+
+	p.wg.Wait()
+	if p.recorder != nil {
+		if err := p.recorder.Flush(); err != nil {
+			logger.Error("recording failed", slog.Any("error", err))
+		}
+	}
+	c.fill(&s)
+	s.PerChannel = amounts
+	if p.bigSums {
+		s.BigInputSum, s.BigOutputSum = &bigIn.v, &bigOut.v
+	}
+	s.Workers = workers
+	s.Err = b.failure()
+	if s.Err == nil {
+		s.Err = abort.failure()
+	}
+	s.StopReason = stopReason(genCtx, workCtx)
+	if s.Err != nil {
+		s.StopReason = s.Err
+	}
+	if p.latency {
+		s.Latency = tr.latency()
+	}
+	if p.gaps {
+		s.MissingSeqs = tr.missing()
+	}
+	if p.invariants {
+		if err := CheckInvariants(s); err != nil {
+			logger.Error("invariants violated", slog.Any("error", err))
+			if p.strict {
+				panic(err)
+			}
+			if s.Err == nil {
+				s.Err = err
+			}
+		}
+	}
+
+	logger.Info("pipeline completed",
+		slog.Int64("input_count", s.InputCount),
+		slog.Int64("input_sum", s.InputSum),
+		slog.Int64("output_count", s.OutputCount),
+		slog.Int64("output_sum", s.OutputSum),
+		slog.Int64("filtered", s.Filtered),
+		slog.Int64("dropped", s.Dropped),
+		slog.Int64("dead_lettered", s.DeadLettered),
+		slog.Any("stop_reason", s.StopReason),
+	)
+	if events != nil {
+		events.send(Completed{Stats: s})
+		close(events)
+	}
+	return s
+}
+
+// Close прерывает текущий запуск, как отмена контекста генератора, с
+// причиной ErrClosed: выданные числа дообрабатываются, и Run возвращается
+// как обычно. Без запуска Close ничего не делает, и конвейер можно
+// запускать дальше. Ошибка всегда nil; она нужна для io.Closer.
+func (p *Pipeline) Close() error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if p.stop != nil {
+		p.stop(ErrClosed)
+	}
+	return nil
+}
+
+// setStop запоминает функцию остановки текущего запуска для Close.
+func (p *Pipeline) setStop(stop context.CancelCauseFunc) {
+	p.mu.Lock()
+	p.stop = stop
+	p.mu.Unlock()
+}
+
+// errWorkersDone — причина внутренней остановки генератора после
+// завершения воркеров; в Stats.StopReason она не попадает.
+var errWorkersDone = errors.New("воркеры завершились")
+
+// stopReason возвращает причину остановки запуска: отмену контекста
+// генератора genCtx, если она была не из-за завершения воркеров, иначе
+// отмену контекста воркеров workCtx.
+func stopReason(genCtx, workCtx context.Context) error {
+	if err := context.Cause(genCtx); err != nil && err != errWorkersDone {
+		return err
+	}
+	if workCtx.Err() != nil {
+		return context.Cause(workCtx)
+	}
+	return nil
+}
+
+// reportProgress отправляет в p.progress снимок s и c каждые
+// p.progressEvery, пока не закрыт done или не отменён ctx.
+func (p *Pipeline) reportProgress(ctx context.Context, done <-chan struct{}, s *Stats, c *counters) {
+	ticker := p.clock.NewTicker(p.progressEvery)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-done:
+			return
+		case <-ticker.C():
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-done:
+			return
+		case p.progress <- s.snapshot(c):
+		}
+	}
+}
+
+// snapshot возвращает копию счётчиков s, которые во время запуска
+// меняются атомарно, дополненную текущими значениями c.
+func (s *Stats) snapshot(c *counters) Stats {
+	snap := Stats{
+		RunID:           s.RunID,
+		Transformed:     s.Transformed,
+		SampleRate:      s.SampleRate,
+		Filtered:        atomic.LoadInt64(&s.Filtered),
+		FilteredSum:     atomic.LoadInt64(&s.FilteredSum),
+		Dropped:         atomic.LoadInt64(&s.Dropped),
+		DroppedSum:      atomic.LoadInt64(&s.DroppedSum),
+		PanicCount:      atomic.LoadInt64(&s.PanicCount),
+		Invalid:         atomic.LoadInt64(&s.Invalid),
+		DeadLettered:    atomic.LoadInt64(&s.DeadLettered),
+		DeadLetteredSum: atomic.LoadInt64(&s.DeadLetteredSum),
+	}
+	c.fill(&snap)
+	return snap
+}
+
+// workerInputs возвращает входной канал для каждого воркера. Обычно это
+// один и тот же общий канал in; с опциями WithWeights и WithHashPartition
+// у каждого воркера свой канал, который заполняет WeightedFanOut или
+// HashFanOut. Потерянное при отмене
+// число учитывается в s и удаляется из tr.
+func (p *Pipeline) workerInputs(ctx context.Context, in <-chan int64, s *Stats, tr *flightTracker) []<-chan int64 {
+	ins := make([]<-chan int64, p.workers)
+	if len(p.weights) == 0 && !p.partition {
+		for i := range ins {
+			ins[i] = in
+		}
+		return ins
+	}
+
+	outs := make([]chan<- int64, p.workers)
+	for i := range ins {
+		ch := make(chan int64)
+		ins[i], outs[i] = ch, ch
+	}
+	p.goStage(func() {
+		var v int64
+		var lost bool
+		if p.partition {
+			v, lost = HashFanOut(ctx, in, outs, p.hash)
+		} else {
+			v, lost = WeightedFanOut(ctx, in, outs, p.weights)
+		}
+		if lost {
+			p.drop(s, tr, v)
+		}
+	})
+	return ins
+}
+
+// drop учитывает в s число v, которое из-за остановки уже не дойдёт до
+// воркеров, убирает его из tr и передаёт в WithOnDrop.
+func (p *Pipeline) drop(s *Stats, tr *flightTracker, v int64) {
+	p.flow.Load().leave()
+	atomic.AddInt64(&s.Dropped, 1)
+	p.metrics.addDropped()
+	if p.sample.keep(v) {
+		atomic.AddInt64(&s.DroppedSum, v)
+	}
+	tr.dropInput(v)
+	if p.onDrop != nil {
+		p.onDrop(v)
+	}
+}
+
+// bigSum — сумма произвольной точности, безопасная для параллельного
+// сложения. Методы безопасны для nil-указателя и тогда ничего не делают.
+type bigSum struct {
+	mu sync.Mutex
+	v  big.Int
+	x  big.Int // слагаемое, чтобы не выделять память на каждое число
+}
+
+func (b *bigSum) add(v int64) {
+	if b == nil {
+		return
+	}
+	b.mu.Lock()
+	b.v.Add(&b.v, b.x.SetInt64(v))
+	b.mu.Unlock()
+}
+
+// fanOut запускает по воркеру на каждый канал ins с опциями opts(i), где
+// i — номер воркера, и возвращает их выходные каналы. Если withStats истинно, возвращается
+// также статистика каждого воркера, иначе второй результат равен nil.
+// События запуска и остановки воркеров пишутся в logger.
+func (p *Pipeline) fanOut(ctx context.Context, ins []<-chan int64, opts func(id int) []WorkerOption, withStats bool, logger *slog.Logger) ([]chan int64, []*WorkerStat) {
+	outs := make([]chan int64, p.workers)
+	var stats []*WorkerStat
+	if withStats {
+		stats = make([]*WorkerStat, p.workers)
+	}
+
+	for i := range outs {
+		out := make(chan int64)
+		outs[i] = out
+
+		opts := opts(i)
+		if withStats {
+			stats[i] = &WorkerStat{}
+			opts = append(opts[:len(opts):len(opts)], WithStat(stats[i]))
+		}
+		id, in := i, ins[i]
+		events := p.runEvents
+		p.goLabeled(pprof.Labels("stage", "worker", "id", strconv.Itoa(id)), func() {
+			logger.Debug("worker started", slog.Int("worker", id))
+			events.send(WorkerStarted{ID: id})
+			Worker(ctx, in, out, opts...)
+			logger.Debug("worker stopped", slog.Int("worker", id))
+			events.send(WorkerStopped{ID: id})
+		})
+	}
+	return outs, stats
+}
+
+// filterOuts ставит Filter на выход каждого канала outs и возвращает
+// каналы с прошедшими фильтр числами. Отброшенные числа учитываются в s
+// и удаляются из tr.
+func (p *Pipeline) filterOuts(outs []chan int64, s *Stats, tr *flightTracker, b *panicBoundary) []chan int64 {
+	filtered := make([]chan int64, len(outs))
+	for i, out := range outs {
+		in := out
+		filtered[i] = make(chan int64)
+		dst := filtered[i]
+		p.goStage(func() {
+			dropped := Filter(in, dst, func(v int64) bool {
+				if b.test(p.filter, v) {
+					return true
+				}
+				if p.sample.keep(v) {
+					atomic.AddInt64(&s.FilteredSum, v)
+				}
+				p.metrics.addFiltered()
+				p.flow.Load().leave()
+				tr.drop(v)
+				return false
+			})
+			atomic.AddInt64(&s.Filtered, dropped)
+		})
+	}
+	return filtered
+}
+
+// fanIn сливает каналы outs в один результирующий канал и считает,
+// сколько чисел пришло из каждого. Слайс amounts можно читать после
+// закрытия результирующего канала.
+//
+// Каждый канал конвейера закрывает только тот, кто в него пишет, и
+// только после своей последней отправки: воркер и фильтр — отложенным
+// close, когда вышли из цикла, а результирующий канал — отдельная
+// горутина после wg.Wait, то есть когда все пересылающие горутины уже
+// дочитали свои outs и вышли. Отмена контекста не закрывает ни одного
+// канала напрямую, она лишь заставляет писателей выйти, поэтому отправка
+// в закрытый канал невозможна при отмене в любой момент.
+func (p *Pipeline) fanIn(outs []chan int64) (<-chan int64, []int64) {
+	// amounts — слайс, в который собирается статистика по горутинам
+	amounts := make([]int64, len(outs))
+	size := len(outs)
+	if p.outputBuffer > 0 {
+		size = p.outputBuffer
+	}
+	chOut := make(chan int64, size)
+
+	var wg sync.WaitGroup
+
+	// 4. Собираем числа из каналов outs
+	for i, out := range outs {
+		i, out := i, out
+		wg.Add(1)
+		p.goStage(func() {
+			defer wg.Done()
+			for v := range out {
+				amounts[i]++
+				chOut <- v
+			}
+		})
+	}
+
+	p.goStage(func() {
+		// ждём завершения работы всех горутин для outs
+		wg.Wait()
+		// закрываем результирующий канал
+		close(chOut)
+	})
+
+	return chOut, amounts
+}
@@ -0,0 +1,943 @@
+package pipeline
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"math/rand"
+	"runtime"
+	"runtime/pprof"
+	"slices"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestPoolProcessErrAborts(t *testing.T) {
+	errBoom := errors.New("boom")
+	p := NewPipeline(4, WithPoolProcessErr(func(v int64) (int64, error) {
+		if v == 50 {
+			return 0, errBoom
+		}
+		return v, nil
+	}))
+
+	start := time.Now()
+	s, err := p.RunFor(context.Background(), 10*time.Second)
+	if elapsed := time.Since(start); elapsed > 2*time.Second {
+		t.Fatalf("запуск не прервался на ошибке: %v", elapsed)
+	}
+	if !errors.Is(err, errBoom) {
+		t.Fatalf("RunFor = %v, want %v", err, errBoom)
+	}
+	if !errors.Is(s.Err, errBoom) || !errors.Is(s.StopReason, errBoom) {
+		t.Fatalf("Err = %v, StopReason = %v", s.Err, s.StopReason)
+	}
+	if s.DeadLettered != 1 {
+		t.Fatalf("DeadLettered = %d, want 1", s.DeadLettered)
+	}
+	if err := CheckInvariants(s); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestPoolProcessErrHappyPath(t *testing.T) {
+	p := NewPipeline(4, WithPoolProcessErr(func(v int64) (int64, error) {
+		return 2 * v, nil
+	}))
+
+	s, err := p.RunFor(context.Background(), 50*time.Millisecond)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !errors.Is(s.StopReason, ErrRunTimeout) {
+		t.Fatalf("StopReason = %v, want %v", s.StopReason, ErrRunTimeout)
+	}
+	if s.OutputSum != 2*s.InputSum || s.DeadLettered != 0 {
+		t.Fatalf("InputSum = %d, OutputSum = %d, DeadLettered = %d", s.InputSum, s.OutputSum, s.DeadLettered)
+	}
+	if err := CheckInvariants(s); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// recordClock — Clock, который не ждёт, а только запоминает
+// запрошенные паузы.
+type recordClock struct {
+	mu     sync.Mutex
+	pauses []time.Duration
+}
+
+func (c *recordClock) Now() time.Time { return time.Now() }
+
+func (c *recordClock) After(d time.Duration) <-chan time.Time {
+	c.mu.Lock()
+	c.pauses = append(c.pauses, d)
+	c.mu.Unlock()
+
+	ch := make(chan time.Time, 1)
+	ch <- time.Now()
+	return ch
+}
+
+func (c *recordClock) NewTicker(d time.Duration) Ticker { return RealClock.NewTicker(d) }
+
+// snapshot возвращает копию запрошенных пауз.
+func (c *recordClock) snapshot() []time.Duration {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return slices.Clone(c.pauses)
+}
+
+func TestWorkerSeedsDiffer(t *testing.T) {
+	const spread = time.Hour
+	// first возвращает первую паузу воркера с начальным значением seed
+	first := func(seed int64) time.Duration {
+		return time.Duration(rand.New(rand.NewSource(seed)).Int63n(int64(spread)))
+	}
+
+	tests := []struct {
+		name string
+		opts []Option
+		base int64
+	}{
+		{"default", nil, defaultSeed},
+		{"seed base", []Option{WithSeedBase(10)}, 10},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			clock := &recordClock{}
+			// каждый из двух воркеров берёт ровно одно число: первый ждёт
+			// в обработке, пока второй не возьмёт своё
+			var taken sync.WaitGroup
+			taken.Add(2)
+			opts := append([]Option{
+				WithPipelineClock(clock),
+				WithWorkerOptions(WithJitter(0, spread)),
+				WithSource([]int64{1, 2}),
+				WithPoolProcess(func(v int64) int64 {
+					taken.Done()
+					taken.Wait()
+					return v
+				}),
+			}, tt.opts...)
+			s := NewPipeline(2, opts...).Run(context.Background())
+			if err := CheckInvariants(s); err != nil {
+				t.Fatal(err)
+			}
+
+			got := slices.Sorted(slices.Values(clock.pauses))
+			want := slices.Sorted(slices.Values([]time.Duration{first(tt.base), first(tt.base + 1)}))
+			if !slices.Equal(got, want) {
+				t.Fatalf("паузы %v, want %v", got, want)
+			}
+		})
+	}
+}
+
+func TestOutputBufferCorrectness(t *testing.T) {
+	values := make([]int64, 500)
+	var want int64
+	for i := range values {
+		values[i] = int64(i + 1)
+		want += values[i]
+	}
+	for _, size := range []int{1, 4, 64, 1024} {
+		s := NewPipeline(4, WithSource(values), WithOutputBuffer(size)).Run(context.Background())
+		if s.OutputCount != int64(len(values)) || s.OutputSum != want {
+			t.Fatalf("буфер %d: OutputCount = %d, OutputSum = %d, want %d, %d",
+				size, s.OutputCount, s.OutputSum, len(values), want)
+		}
+		if err := CheckInvariants(s); err != nil {
+			t.Fatalf("буфер %d: %v", size, err)
+		}
+	}
+}
+
+// BenchmarkOutputBuffer сравнивает пропускную способность при разных
+// размерах результирующего канала; 0 — размер по умолчанию. Воркеры
+// работают без пауз, чтобы узким местом было слияние каналов.
+func BenchmarkOutputBuffer(b *testing.B) {
+	const workers = 16
+	for _, size := range []int{0, 1, 4 * workers, 64 * workers} {
+		b.Run(fmt.Sprintf("buffer=%d", size), func(b *testing.B) {
+			var count int64
+			for range b.N {
+				s, err := NewPipeline(workers,
+					WithOutputBuffer(size), WithWorkerOptions(WithJitter(0, 0))).RunFor(context.Background(), 50*time.Millisecond)
+				if err != nil {
+					b.Fatal(err)
+				}
+				count += s.OutputCount
+			}
+			b.ReportMetric(float64(count)/b.Elapsed().Seconds(), "items/s")
+		})
+	}
+}
+
+func TestActiveGoroutines(t *testing.T) {
+	p := NewPipeline(4)
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan Stats)
+	go func() {
+		done <- p.Run(ctx)
+	}()
+
+	deadline := time.Now().Add(time.Second)
+	for p.ActiveGoroutines() == 0 {
+		if time.Now().After(deadline) {
+			t.Fatal("ActiveGoroutines = 0 во время запуска")
+		}
+		time.Sleep(time.Millisecond)
+	}
+	cancel()
+	s := <-done
+	if n := p.ActiveGoroutines(); n != 0 {
+		t.Fatalf("ActiveGoroutines = %d после Run, want 0", n)
+	}
+	if err := CheckInvariants(s); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestWorkerStatsSum(t *testing.T) {
+	values := make([]int64, 300)
+	for i := range values {
+		values[i] = int64(i)
+	}
+	s := NewPipeline(5, WithWorkerStats(), WithSource(values)).Run(context.Background())
+	if len(s.Workers) != 5 {
+		t.Fatalf("Workers из %d элементов, want 5", len(s.Workers))
+	}
+	var processed int64
+	for _, w := range s.Workers {
+		processed += w.Processed
+	}
+	if processed != s.OutputCount || processed != int64(len(values)) {
+		t.Fatalf("воркеры обработали %d, OutputCount = %d, want %d", processed, s.OutputCount, len(values))
+	}
+}
+
+func TestRunStagesCancelWorkers(t *testing.T) {
+	workCtx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+
+	done := make(chan Stats)
+	go func() {
+		// генератор не отменяется: он должен остановиться сам, когда
+		// его числа станет некому читать
+		done <- NewPipeline(4).RunStages(context.Background(), workCtx)
+	}()
+	select {
+	case s := <-done:
+		if s.InputCount == 0 {
+			t.Fatal("InputCount = 0")
+		}
+		if err := CheckInvariants(s); err != nil {
+			t.Fatal(err)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("генератор не остановился после отмены воркеров")
+	}
+}
+
+// jsonLogger возвращает логгер, пишущий JSON в память, и функцию, которая
+// разбирает записанные строки.
+func jsonLogger(t *testing.T) (*slog.Logger, func() []map[string]any) {
+	var buf bytes.Buffer
+	logger := slog.New(slog.NewJSONHandler(&buf, &slog.HandlerOptions{Level: slog.LevelDebug}))
+	return logger, func() []map[string]any {
+		var records []map[string]any
+		sc := bufio.NewScanner(&buf)
+		for sc.Scan() {
+			var r map[string]any
+			if err := json.Unmarshal(sc.Bytes(), &r); err != nil {
+				t.Fatalf("запись %q: %v", sc.Text(), err)
+			}
+			records = append(records, r)
+		}
+		return records
+	}
+}
+
+func TestCompletionLog(t *testing.T) {
+	logger, records := jsonLogger(t)
+	s, err := NewPipeline(3, WithLogger(logger)).RunFor(context.Background(), 20*time.Millisecond)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var completed map[string]any
+	for _, r := range records() {
+		if r["msg"] == "pipeline completed" {
+			completed = r
+		}
+	}
+	if completed == nil {
+		t.Fatal("нет записи pipeline completed")
+	}
+	// JSON хранит числа как float64
+	want := map[string]int64{
+		"input_count":  s.InputCount,
+		"input_sum":    s.InputSum,
+		"output_count": s.OutputCount,
+		"output_sum":   s.OutputSum,
+	}
+	for key, v := range want {
+		if got, ok := completed[key].(float64); !ok || int64(got) != v {
+			t.Errorf("%s = %v, want %d", key, completed[key], v)
+		}
+	}
+}
+
+func TestConsumersNoDoubleCount(t *testing.T) {
+	values := make([]int64, 1000)
+	var want int64
+	for i := range values {
+		values[i] = int64(i + 1)
+		want += values[i]
+	}
+	var count, sum atomic.Int64
+	s := NewPipeline(4, WithConsumers(4), WithSource(values), WithSink(func(v int64) {
+		count.Add(1)
+		sum.Add(v)
+	})).Run(context.Background())
+
+	if count.Load() != int64(len(values)) || sum.Load() != want {
+		t.Fatalf("sink получил %d чисел с суммой %d, want %d, %d", count.Load(), sum.Load(), len(values), want)
+	}
+	if s.OutputCount != int64(len(values)) || s.OutputSum != want {
+		t.Fatalf("OutputCount = %d, OutputSum = %d, want %d, %d", s.OutputCount, s.OutputSum, len(values), want)
+	}
+}
+
+func TestDeterministicOrder(t *testing.T) {
+	const n = 500
+	var got []int64
+	s := NewPipeline(8, WithDeterministic(), WithConsumers(4), WithTake(n), WithSink(func(v int64) {
+		got = append(got, v)
+	})).Run(context.Background())
+
+	if len(got) != n {
+		t.Fatalf("получено %d чисел, want %d", len(got), n)
+	}
+	for i, v := range got {
+		if v != int64(i+1) {
+			t.Fatalf("на месте %d число %d, want %d", i, v, i+1)
+		}
+	}
+	if err := CheckInvariants(s); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestManyWorkersShortRun(t *testing.T) {
+	const workers = 1000
+	done := make(chan Stats)
+	go func() {
+		s, _ := NewPipeline(workers, WithMaxWorkers(0)).RunFor(context.Background(), time.Millisecond)
+		done <- s
+	}()
+	select {
+	case s := <-done:
+		if len(s.PerChannel) != workers {
+			t.Fatalf("PerChannel из %d элементов, want %d", len(s.PerChannel), workers)
+		}
+		if err := CheckInvariants(s); err != nil {
+			t.Fatal(err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("запуск с 1000 воркеров не завершился")
+	}
+}
+
+func TestMaxWorkersClamp(t *testing.T) {
+	const requested = 100000
+	limit := workersPerProc * runtime.GOMAXPROCS(0)
+	logger, records := jsonLogger(t)
+	p := NewPipeline(requested, WithLogger(logger))
+	if p.Workers() != limit {
+		t.Fatalf("Workers() = %d, want %d", p.Workers(), limit)
+	}
+
+	s, err := p.RunFor(context.Background(), 10*time.Millisecond)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(s.PerChannel) != limit {
+		t.Fatalf("PerChannel из %d элементов, want %d", len(s.PerChannel), limit)
+	}
+	if err := CheckInvariants(s); err != nil {
+		t.Fatal(err)
+	}
+
+	var capped map[string]any
+	for _, r := range records() {
+		if r["msg"] == "workers capped" {
+			capped = r
+		}
+	}
+	if capped == nil || capped["requested"] != float64(requested) || capped["workers"] != float64(limit) {
+		t.Fatalf("запись об ограничении = %v", capped)
+	}
+
+	if n := NewPipeline(requested, WithMaxWorkers(8)).Workers(); n != 8 {
+		t.Fatalf("Workers() с WithMaxWorkers(8) = %d, want 8", n)
+	}
+}
+
+func TestResetRunsIndependently(t *testing.T) {
+	p := NewPipeline(3, WithTake(100), WithWorkerStats())
+	first := p.Run(context.Background())
+	if err := p.Reset(); err != nil {
+		t.Fatal(err)
+	}
+	second := p.Run(context.Background())
+
+	for _, s := range []Stats{first, second} {
+		if s.InputCount != 100 || s.InputSum != 5050 || s.OutputCount != 100 {
+			t.Fatalf("InputCount = %d, InputSum = %d, OutputCount = %d, want 100, 5050, 100",
+				s.InputCount, s.InputSum, s.OutputCount)
+		}
+	}
+	var processed int64
+	for _, w := range second.Workers {
+		processed += w.Processed
+	}
+	if processed != 100 {
+		t.Fatalf("во втором запуске воркеры обработали %d, want 100", processed)
+	}
+	if first.RunID == second.RunID {
+		t.Fatalf("у запусков одинаковый RunID %q", first.RunID)
+	}
+}
+
+func TestResetWhileRunning(t *testing.T) {
+	p := NewPipeline(2)
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		p.Run(ctx)
+	}()
+	for p.ActiveGoroutines() == 0 {
+		time.Sleep(time.Millisecond)
+	}
+	if err := p.Reset(); !errors.Is(err, ErrRunning) {
+		t.Fatalf("Reset = %v, want %v", err, ErrRunning)
+	}
+	cancel()
+	<-done
+}
+
+func TestConcurrentRunRejected(t *testing.T) {
+	p := NewPipeline(2)
+	ctx, cancel := context.WithCancel(context.Background())
+	if err := p.Start(ctx); err != nil {
+		t.Fatal(err)
+	}
+
+	// пока идёт запуск Start, любой другой запуск и сброс отклоняются
+	if s := p.Run(context.Background()); !errors.Is(s.Err, ErrRunning) || !errors.Is(s.StopReason, ErrRunning) {
+		t.Fatalf("Run: Err = %v, StopReason = %v, want %v", s.Err, s.StopReason, ErrRunning)
+	}
+	if _, err := p.RunFor(context.Background(), time.Second); !errors.Is(err, ErrRunning) {
+		t.Fatalf("RunFor = %v, want %v", err, ErrRunning)
+	}
+	if err := p.Start(context.Background()); !errors.Is(err, ErrRunning) {
+		t.Fatalf("повторный Start = %v, want %v", err, ErrRunning)
+	}
+	if err := p.Reset(); !errors.Is(err, ErrRunning) {
+		t.Fatalf("Reset = %v, want %v", err, ErrRunning)
+	}
+
+	cancel()
+	if s := p.Wait(); s.RunID == "" {
+		t.Fatal("Wait не вернул статистику запуска Start")
+	}
+	if err := p.Reset(); err != nil {
+		t.Fatal(err)
+	}
+	if s := p.Wait(); s.InputCount != 0 || s.RunID != "" {
+		t.Fatalf("Wait после Reset = %+v, want пустую статистику", s)
+	}
+
+	// и наоборот: Start отклоняется, пока идёт Run
+	ctx, cancel = context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		p.Run(ctx)
+	}()
+	for p.ActiveGoroutines() == 0 {
+		time.Sleep(time.Millisecond)
+	}
+	if err := p.Start(context.Background()); !errors.Is(err, ErrRunning) {
+		t.Fatalf("Start во время Run = %v, want %v", err, ErrRunning)
+	}
+	cancel()
+	<-done
+}
+
+func TestRunIDInLogs(t *testing.T) {
+	const id = "run-42"
+	logger, records := jsonLogger(t)
+	ctx, cancel := context.WithTimeout(WithRunID(context.Background(), id), 10*time.Millisecond)
+	defer cancel()
+	s := NewPipeline(2, WithLogger(logger)).Run(ctx)
+	if s.RunID != id {
+		t.Fatalf("RunID = %q, want %q", s.RunID, id)
+	}
+
+	seen := map[string]int{}
+	for _, r := range records() {
+		if r["run_id"] != id {
+			t.Fatalf("запись %v без run_id %q", r, id)
+		}
+		seen[r["msg"].(string)]++
+	}
+	for msg, want := range map[string]int{
+		"pipeline started":   1,
+		"generator started":  1,
+		"generator stopped":  1,
+		"worker started":     2,
+		"worker stopped":     2,
+		"pipeline completed": 1,
+	} {
+		if seen[msg] != want {
+			t.Errorf("записей %q: %d, want %d", msg, seen[msg], want)
+		}
+	}
+}
+
+func TestWeightedWorkers(t *testing.T) {
+	values := make([]int64, 400)
+	for i := range values {
+		values[i] = int64(i + 1)
+	}
+	s := NewPipeline(1, WithWeights(1, 3), WithSource(values)).Run(context.Background())
+	if len(s.PerChannel) != 2 {
+		t.Fatalf("PerChannel = %v, want два канала", s.PerChannel)
+	}
+	light, heavy := s.PerChannel[0], s.PerChannel[1]
+	if light == 0 || heavy < 3*light-3 || heavy > 3*light+3 {
+		t.Fatalf("PerChannel = %v, want примерно 1:3", s.PerChannel)
+	}
+	if err := CheckInvariants(s); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestRunForDuration(t *testing.T) {
+	const d = 50 * time.Millisecond
+	start := time.Now()
+	s, err := NewPipeline(4).RunFor(context.Background(), d)
+	elapsed := time.Since(start)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// дообработка после остановки генератора занимает не больше паузы воркера
+	if elapsed < d || elapsed > d+500*time.Millisecond {
+		t.Fatalf("RunFor(%v) длился %v", d, elapsed)
+	}
+	if s.InputCount == 0 || s.OutputCount == 0 {
+		t.Fatalf("InputCount = %d, OutputCount = %d, want больше нуля", s.InputCount, s.OutputCount)
+	}
+	if err := CheckInvariants(s); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestPoolProcessDoubles(t *testing.T) {
+	s, err := NewPipeline(4, WithPoolProcess(func(v int64) int64 {
+		return 2 * v
+	})).RunFor(context.Background(), 30*time.Millisecond)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if s.InputCount == 0 || s.OutputSum != 2*s.InputSum {
+		t.Fatalf("InputSum = %d, OutputSum = %d, want вдвое больше", s.InputSum, s.OutputSum)
+	}
+	if !s.Transformed {
+		t.Fatal("Transformed = false")
+	}
+	if err := CheckInvariants(s); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestProgressReports(t *testing.T) {
+	progress := make(chan Stats, 10)
+	s, err := NewPipeline(2, WithProgressInterval(50*time.Millisecond, progress)).
+		RunFor(context.Background(), 150*time.Millisecond)
+	if err != nil {
+		t.Fatal(err)
+	}
+	close(progress)
+
+	var reports []Stats
+	for snap := range progress {
+		reports = append(reports, snap)
+	}
+	if len(reports) < 2 {
+		t.Fatalf("снимков %d, want не меньше 2", len(reports))
+	}
+	for i, snap := range reports {
+		if snap.InputCount > s.InputCount || i > 0 && snap.InputCount < reports[i-1].InputCount {
+			t.Fatalf("снимок %d: InputCount = %d, итог %d", i, snap.InputCount, s.InputCount)
+		}
+	}
+}
+
+func TestStopReasonTimeoutAndCancel(t *testing.T) {
+	t.Run("timeout", func(t *testing.T) {
+		s, err := NewPipeline(2).RunFor(context.Background(), 10*time.Millisecond)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !errors.Is(s.StopReason, ErrRunTimeout) {
+			t.Fatalf("StopReason = %v, want %v", s.StopReason, ErrRunTimeout)
+		}
+	})
+	t.Run("cancel", func(t *testing.T) {
+		ctx, cancel := context.WithCancel(context.Background())
+		time.AfterFunc(10*time.Millisecond, cancel)
+		s, err := NewPipeline(2).RunFor(ctx, time.Minute)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !errors.Is(s.StopReason, context.Canceled) || errors.Is(s.StopReason, ErrRunTimeout) {
+			t.Fatalf("StopReason = %v, want %v", s.StopReason, context.Canceled)
+		}
+	})
+}
+
+func TestSlowWorkerIsolated(t *testing.T) {
+	s, err := NewPipeline(4, WithWorkerStats(), WithPoolProcessID(func(id int, v int64) int64 {
+		if id == 0 {
+			time.Sleep(20 * time.Millisecond)
+		}
+		return v
+	})).RunFor(context.Background(), 100*time.Millisecond)
+	if err != nil {
+		t.Fatal(err)
+	}
+	slow := s.Workers[0].Processed
+	for id, w := range s.Workers[1:] {
+		if w.Processed < 3*max(slow, 1) {
+			t.Fatalf("воркер %d обработал %d чисел, медленный — %d", id+1, w.Processed, slow)
+		}
+	}
+	if err := CheckInvariants(s); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestStopReasonCauses(t *testing.T) {
+	errCustom := errors.New("своя причина")
+	errBoom := errors.New("boom")
+
+	tests := []struct {
+		name string
+		run  func() Stats
+		want func(error) bool
+	}{
+		{"timeout", func() Stats {
+			s, _ := NewPipeline(2).RunFor(context.Background(), 5*time.Millisecond)
+			return s
+		}, func(err error) bool { return errors.Is(err, ErrRunTimeout) }},
+		{"limit", func() Stats {
+			return NewPipeline(2, WithTake(10)).Run(context.Background())
+		}, func(err error) bool { return errors.Is(err, ErrLimitReached) }},
+		{"close", func() Stats {
+			p := NewPipeline(2)
+			if err := p.Start(context.Background()); err != nil {
+				t.Fatal(err)
+			}
+			p.Close()
+			return p.Wait()
+		}, func(err error) bool { return errors.Is(err, ErrClosed) }},
+		{"custom cause", func() Stats {
+			ctx, cancel := context.WithCancelCause(context.Background())
+			time.AfterFunc(5*time.Millisecond, func() { cancel(errCustom) })
+			return NewPipeline(2).Run(ctx)
+		}, func(err error) bool { return errors.Is(err, errCustom) }},
+		{"source exhausted", func() Stats {
+			return NewPipeline(2, WithSource([]int64{1, 2, 3})).Run(context.Background())
+		}, func(err error) bool { return err == nil }},
+		{"process error", func() Stats {
+			return NewPipeline(2, WithPoolProcessErr(func(int64) (int64, error) { return 0, errBoom })).Run(context.Background())
+		}, func(err error) bool { return errors.Is(err, errBoom) }},
+		{"panic", func() Stats {
+			return NewPipeline(2, WithPanicRecovery(), WithSink(func(int64) { panic("sink") })).Run(context.Background())
+		}, func(err error) bool {
+			var pe *RunPanicError
+			return errors.As(err, &pe)
+		}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := tt.run()
+			if !tt.want(s.StopReason) {
+				t.Fatalf("StopReason = %v", s.StopReason)
+			}
+		})
+	}
+}
+
+func TestMaxInFlightCeiling(t *testing.T) {
+	const ceiling = 4
+	var p *Pipeline
+	var peak atomic.Int64
+	p = NewPipeline(8, WithMaxInFlight(ceiling), WithInputBuffer(32), WithSink(func(int64) {
+		if n := p.InFlight(); n > peak.Load() {
+			peak.Store(n)
+		}
+		// медленный потребитель
+		time.Sleep(200 * time.Microsecond)
+	}))
+	s, err := p.RunFor(context.Background(), 50*time.Millisecond)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if peak.Load() == 0 || peak.Load() > ceiling {
+		t.Fatalf("наибольшее InFlight = %d, want от 1 до %d", peak.Load(), ceiling)
+	}
+	if err := CheckInvariants(s); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestInFlightCountsBeforeSend(t *testing.T) {
+	var p *Pipeline
+	var low atomic.Int64
+	low.Store(1)
+	// число, которое обрабатывает воркер, уже учтено в пути
+	p = NewPipeline(4, WithPoolProcess(func(v int64) int64 {
+		if n := p.InFlight(); n < low.Load() {
+			low.Store(n)
+		}
+		return v
+	}))
+	if _, err := p.RunFor(context.Background(), 20*time.Millisecond); err != nil {
+		t.Fatal(err)
+	}
+	if low.Load() < 1 {
+		t.Fatalf("наименьшее InFlight в воркере = %d, want не меньше 1", low.Load())
+	}
+}
+
+func TestPoolProcessIDDistribution(t *testing.T) {
+	const workers = 4
+	var mu sync.Mutex
+	byWorker := make([]int64, workers)
+	s, err := NewPipeline(workers,
+		// номер воркера кодируется в младших разрядах результата
+		WithPoolProcessID(func(id int, v int64) int64 { return v*1000 + int64(id) }),
+		WithSink(func(v int64) {
+			mu.Lock()
+			byWorker[v%1000]++
+			mu.Unlock()
+		}),
+	).RunFor(context.Background(), 30*time.Millisecond)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !slices.Equal(byWorker, s.PerChannel) {
+		t.Fatalf("по номерам в результатах %v, PerChannel %v", byWorker, s.PerChannel)
+	}
+}
+
+func TestOnDropReceivesUndelivered(t *testing.T) {
+	var mu sync.Mutex
+	var dropped []int64
+	p := NewPipeline(1, WithInputBuffer(64),
+		WithPoolProcess(func(v int64) int64 {
+			time.Sleep(2 * time.Millisecond)
+			return v
+		}),
+		WithOnDrop(func(v int64) {
+			mu.Lock()
+			dropped = append(dropped, v)
+			mu.Unlock()
+		}))
+
+	// останавливаются сразу и генератор, и воркеры: буфер не дочитан
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	s := p.RunStages(ctx, ctx)
+
+	var sum int64
+	for _, v := range dropped {
+		sum += v
+	}
+	if s.Dropped == 0 || int64(len(dropped)) != s.Dropped || sum != s.DroppedSum {
+		t.Fatalf("hook получил %d чисел с суммой %d, Dropped = %d, DroppedSum = %d",
+			len(dropped), sum, s.Dropped, s.DroppedSum)
+	}
+	if err := CheckInvariants(s); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestRunPhasesDrainsAll(t *testing.T) {
+	p := NewPipeline(2, WithInputBuffer(16), WithPoolProcess(func(v int64) int64 {
+		time.Sleep(time.Millisecond)
+		return v
+	}))
+	s, err := p.RunPhases(context.Background(), 10*time.Millisecond, time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if s.InputCount == 0 || s.OutputCount != s.InputCount || s.Dropped != 0 {
+		t.Fatalf("InputCount = %d, OutputCount = %d, Dropped = %d", s.InputCount, s.OutputCount, s.Dropped)
+	}
+	if err := CheckInvariants(s); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := p.RunPhases(context.Background(), 0, time.Second); err == nil {
+		t.Fatal("RunPhases с нулевым окном генерации без ошибки")
+	}
+}
+
+func TestOutputValidationInvalidCount(t *testing.T) {
+	values := make([]int64, 20)
+	for i := range values {
+		values[i] = int64(i + 1)
+	}
+	// кратные трём считаются испорченными: 3, 6, ..., 18
+	validate := func(v int64) error {
+		if v%3 == 0 {
+			return fmt.Errorf("%d кратно трём", v)
+		}
+		return nil
+	}
+
+	errs := make(chan error, len(values))
+	s := NewPipeline(3, WithSource(values), WithConsumers(2), WithOutputValidation(validate, errs)).Run(context.Background())
+	close(errs)
+	if s.Invalid != 6 {
+		t.Fatalf("Invalid = %d, want 6", s.Invalid)
+	}
+	// неверные числа не останавливают запуск и доходят до выхода
+	if s.OutputCount != int64(len(values)) {
+		t.Fatalf("OutputCount = %d, want %d", s.OutputCount, len(values))
+	}
+	if n := len(errs); n != 6 {
+		t.Fatalf("ошибок в канале %d, want 6", n)
+	}
+
+	// без канала ошибки только считаются
+	s = NewPipeline(3, WithSource(values), WithOutputValidation(validate, nil)).Run(context.Background())
+	if s.Invalid != 6 {
+		t.Fatalf("Invalid без канала = %d, want 6", s.Invalid)
+	}
+	if err := CheckInvariants(s); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestPprofLabels(t *testing.T) {
+	var once sync.Once
+	var profile bytes.Buffer
+	// профиль снимается из потребителя, пока работают все стадии
+	sink := func(int64) {
+		once.Do(func() {
+			if err := pprof.Lookup("goroutine").WriteTo(&profile, 1); err != nil {
+				t.Error(err)
+			}
+		})
+	}
+	if _, err := NewPipeline(2, WithSink(sink)).RunFor(context.Background(), 10*time.Millisecond); err != nil {
+		t.Fatal(err)
+	}
+	for _, label := range []string{`"stage":"generator"`, `"stage":"worker"`, `"stage":"consumer"`, `"id":"1"`} {
+		if !strings.Contains(profile.String(), label) {
+			t.Fatalf("в профиле горутин нет метки %s", label)
+		}
+	}
+}
+
+func TestStartWait(t *testing.T) {
+	p := NewPipeline(3)
+	if s := p.Wait(); s.InputCount != 0 {
+		t.Fatalf("Wait без Start = %+v, want пустую статистику", s)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	if err := p.Start(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if err := p.Start(ctx); !errors.Is(err, ErrRunning) {
+		t.Fatalf("повторный Start = %v, want ErrRunning", err)
+	}
+	// пока запуск идёт в фоне, вызывающий занят своим делом
+	time.Sleep(10 * time.Millisecond)
+	cancel()
+
+	s := p.Wait()
+	if s.InputCount == 0 {
+		t.Fatal("за время работы не сгенерировано ни одного числа")
+	}
+	if err := CheckInvariants(s); err != nil {
+		t.Fatal(err)
+	}
+	if again := p.Wait(); again.InputCount != s.InputCount || again.OutputSum != s.OutputSum {
+		t.Fatalf("повторный Wait = %+v, want %+v", again, s)
+	}
+
+	// после завершения конвейер можно запустить снова
+	if err := p.Start(context.Background()); err != nil {
+		t.Fatalf("Start после Wait = %v", err)
+	}
+	p.Close()
+	if err := CheckInvariants(p.Wait()); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestInFlightMidRunAndAfter(t *testing.T) {
+	release := make(chan struct{})
+	p := NewPipeline(2, WithPoolProcess(func(v int64) int64 {
+		<-release
+		return v
+	}))
+	if n := p.InFlight(); n != 0 {
+		t.Fatalf("InFlight до запуска = %d, want 0", n)
+	}
+	if err := p.Start(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+
+	// воркеры держат взятые числа, пока их не отпустят
+	deadline := time.Now().Add(time.Second)
+	for p.InFlight() < 2 {
+		if time.Now().After(deadline) {
+			t.Fatalf("InFlight во время запуска = %d, want не меньше 2", p.InFlight())
+		}
+		time.Sleep(time.Millisecond)
+	}
+	flow := p.flow.Load()
+
+	p.Close()
+	close(release)
+	s := p.Wait()
+	if n := p.InFlight(); n != 0 {
+		t.Fatalf("InFlight после запуска = %d, want 0", n)
+	}
+	// счётчик запуска вернулся к нулю сам, а не только сброшен
+	if n := flow.n.Load(); n != 0 {
+		t.Fatalf("счётчик чисел в пути после запуска = %d, want 0", n)
+	}
+	if err := CheckInvariants(s); err != nil {
+		t.Fatal(err)
+	}
+}
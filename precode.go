@@ -1,90 +1,301 @@
-package main
+package pipeline
 
 import (
 	"context"
-	"fmt"
-	"log"
-	"sync"
+	"math/rand"
+	"time"
 )
 
 // Generator генерирует последовательность чисел 1,2,3 и т.д. и
 // отправляет их в канал ch. При этом после записи в канал для каждого числа
 // вызывается функция fn. Она служит для подсчёта количества и суммы
-// сгенерированных чисел.
+// сгенерированных чисел; если считать не нужно, fn может быть nil.
 func Generator(ctx context.Context, ch chan<- int64, fn func(int64)) {
 	// 1. Функция Generator
-	// ...
+	defer close(ch)
+
+	if fn == nil {
+		fn = func(int64) {}
+	}
+
+	var n int64 = 1
+	// select выбирает среди готовых веток случайно, поэтому отмена
+	// проверяется и перед каждой отправкой
+	for ctx.Err() == nil {
+		select {
+		case <-ctx.Done():
+			return
+		case ch <- n:
+			fn(n)
+			n++
+		}
+	}
 }
 
-// Worker читает число из канала in и пишет его в канал out.
-func Worker(in <-chan int64, out chan<- int64) {
-	// 2. Функция Worker
-	// ...
+// WorkerOption настраивает поведение Worker.
+type WorkerOption func(*workerConfig)
+
+// workerConfig — параметры одного воркера.
+type workerConfig struct {
+	delay  time.Duration // минимальная пауза после обработки числа
+	spread time.Duration // ширина случайной добавки к паузе
+	seed   int64         // начальное значение генератора случайных чисел
+	stat   *WorkerStat   // куда записывать статистику, если задано
+	clock  Clock         // источник времени для пауз и статистики
+
+	process    func(int64) int64          // обработка числа перед отправкой, nil — без изменений
+	processErr func(int64) (int64, error) // обработка, которая может не удаться, см. WithProcessErr
+	onError    func(v int64, err error)   // куда сообщать об ошибке processErr
+	onPanic    func(v int64, err error)   // куда сообщать о панике в process, nil — не перехватывать
+
+	idle Backoff // паузы опроса в адаптивном режиме, nil — режим выключен
+
+	onReceive func(int64) // вызывается для каждого взятого числа до обработки, nil — не вызывается
 }
 
-func main() {
-	chIn := make(chan int64)
+// WorkerStat — статистика одного воркера. Поля заполняет сам воркер,
+// читать их безопасно после закрытия его выходного канала.
+//
+// Last — последнее число, которое воркер взял из in: если он остановился
+// по отмене посреди обработки или обработка упала, это число, на котором
+// он был. HasLast ложно, если воркер не взял ни одного числа.
+type WorkerStat struct {
+	Processed int64         // количество обработанных чисел
+	Busy      time.Duration // суммарное время обработки вместе с паузами
+	Last      int64         // последнее взятое число
+	HasLast   bool          // Last задано
+}
 
-	// 3. Создание контекста
-	// ...
+// defaultSeed используется для WithJitter, если WithSeed не задан,
+// чтобы паузы по умолчанию тоже были воспроизводимыми.
+const defaultSeed = 1
 
-	// для проверки будем считать количество и сумму отправленных чисел
-	var inputSum int64   // сумма сгенерированных чисел
-	var inputCount int64 // количество сгенерированных чисел
+// WithJitter заменяет фиксированную паузу в 1 мс на случайную
+// из диапазона [base, base+spread).
+func WithJitter(base, spread time.Duration) WorkerOption {
+	return func(c *workerConfig) {
+		c.delay = base
+		c.spread = spread
+	}
+}
+
+// WithSeed задаёт начальное значение генератора случайных пауз для
+// WithJitter. С одинаковым seed воркер делает одинаковые паузы.
+func WithSeed(seed int64) WorkerOption {
+	return func(c *workerConfig) {
+		c.seed = seed
+	}
+}
 
-	// генерируем числа, считая параллельно их количество и сумму
-	go Generator(ctx, chIn, func(i int64) {
-		inputSum += i
-		inputCount++
-	})
+// WithAdaptiveDelay включает адаптивный режим: воркер не ждёт число,
+// блокируясь на канале, а опрашивает его. Пока канал пуст, пауза между
+// опросами начинается с lo и удваивается при каждом пустом опросе, но
+// не превышает hi. Как только число получено, пауза снова становится
+// равной lo; после каждого числа воркер тоже выдерживает паузу lo.
+// Так занятый воркер почти не простаивает, а простаивающий редко
+// просыпается.
+func WithAdaptiveDelay(lo, hi time.Duration) WorkerOption {
+	return WithAdaptiveBackoff(lo, doublingBackoff{lo: lo, hi: hi})
+}
 
-	const NumOut = 5 // количество обрабатывающих горутин и каналов
-	// outs — слайс каналов, куда будут записываться числа из chIn
-	outs := make([]chan int64, NumOut)
-	for i := 0; i < NumOut; i++ {
-		// создаём каналы и для каждого из них вызываем горутину Worker
-		outs[i] = make(chan int64)
-		go Worker(chIn, outs[i])
+// WithAdaptiveBackoff включает адаптивный режим, как WithAdaptiveDelay,
+// но паузы между пустыми опросами задаёт стратегия b: перед n-м опросом
+// подряд без числа воркер ждёт b.Next(n), полученное число сбрасывает
+// счёт. После каждого числа воркер выдерживает паузу lo.
+func WithAdaptiveBackoff(lo time.Duration, b Backoff) WorkerOption {
+	return func(c *workerConfig) {
+		c.delay = lo
+		c.spread = 0
+		c.idle = b
 	}
+}
 
-	// amounts — слайс, в который собирается статистика по горутинам
-	amounts := make([]int64, NumOut)
-	// chOut — канал, в который будут отправляться числа из горутин `outs[i]`
-	chOut := make(chan int64, NumOut)
+// doublingBackoff — паузы WithAdaptiveDelay: lo перед первой попыткой,
+// затем удвоение, но не больше hi.
+type doublingBackoff struct {
+	lo, hi time.Duration
+}
 
-	var wg sync.WaitGroup
+// Next возвращает паузу перед попыткой attempt.
+func (b doublingBackoff) Next(attempt int) time.Duration {
+	d := b.lo
+	for i := 1; i < attempt && d < b.hi; i++ {
+		d = min(max(2*d, time.Microsecond), b.hi)
+	}
+	return d
+}
 
-	// 4. Собираем числа из каналов outs
-	// ...
+// WithProcess задаёт обработку числа: в out отправляется fn(v) вместо v.
+// По умолчанию число передаётся без изменений.
+func WithProcess(fn func(int64) int64) WorkerOption {
+	return func(c *workerConfig) {
+		c.process = fn
+	}
+}
 
-	go func() {
-		// ждём завершения работы всех горутин для outs
-		wg.Wait()
-		// закрываем результирующий канал
-		close(chOut)
-	}()
+// WithProcessErr задаёт обработку числа, которая может не удаться: в out
+// отправляется результат fn(v), а если fn вернула ошибку, число в out не
+// отправляется, а передаётся вместе с ошибкой в onErr (если он не nil), и
+// воркер продолжает работу. Заменяет WithProcess; паника в fn
+// перехватывается так же, как в WithProcess.
+func WithProcessErr(fn func(int64) (int64, error), onErr func(v int64, err error)) WorkerOption {
+	return func(c *workerConfig) {
+		c.processErr = fn
+		c.onError = onErr
+	}
+}
 
-	var count int64 // количество чисел результирующего канала
-	var sum int64   // сумма чисел результирующего канала
+// WithPanicHandler перехватывает панику в функции WithProcess или
+// WithProcessErr: число, на
+// котором она случилась, не отправляется в out, а передаётся в fn вместе
+// с *PanicError, и воркер продолжает работу. Без этой опции паника
+// завершает программу.
+func WithPanicHandler(fn func(v int64, err error)) WorkerOption {
+	return func(c *workerConfig) {
+		c.onPanic = fn
+	}
+}
 
-	// 5. Читаем числа из результирующего канала
-	// ...
+// WithClock задаёт источник времени для пауз воркера и его статистики.
+// По умолчанию используется RealClock.
+func WithClock(c Clock) WorkerOption {
+	return func(cfg *workerConfig) {
+		cfg.clock = c
+	}
+}
 
-	fmt.Println("Количество чисел", inputCount, count)
-	fmt.Println("Сумма чисел", inputSum, sum)
-	fmt.Println("Разбивка по каналам", amounts)
+// withReceive задаёт функцию, которую воркер вызывает для каждого
+// взятого из in числа до его обработки.
+func withReceive(fn func(int64)) WorkerOption {
+	return func(c *workerConfig) {
+		c.onReceive = fn
+	}
+}
 
-	// проверка результатов
-	if inputSum != sum {
-		log.Fatalf("Ошибка: суммы чисел не равны: %d != %d\n", inputSum, sum)
+// WithStat включает сбор статистики воркера в st.
+func WithStat(st *WorkerStat) WorkerOption {
+	return func(c *workerConfig) {
+		c.stat = st
 	}
-	if inputCount != count {
-		log.Fatalf("Ошибка: количество чисел не равно: %d != %d\n", inputCount, count)
+}
+
+// sleep ждёт d по часам clock или отмены контекста. Возвращает false,
+// если контекст был отменён раньше.
+func sleep(ctx context.Context, clock Clock, d time.Duration) bool {
+	// таймер останавливается сразу, не дожидаясь срабатывания
+	after, stop := afterStop(clock, d)
+	defer stop()
+
+	select {
+	case <-ctx.Done():
+		return false
+	case <-after:
+		return true
 	}
-	for _, v := range amounts {
-		inputCount -= v
+}
+
+// Worker читает число из канала in и пишет его в канал out.
+// После каждого числа воркер делает паузу. При отмене контекста ctx
+// воркер прекращает чтение и закрывает out; уже прочитанное число
+// при этом всё равно передаётся в out.
+func Worker(ctx context.Context, in <-chan int64, out chan<- int64, opts ...WorkerOption) {
+	// 2. Функция Worker
+	defer close(out)
+
+	cfg := workerConfig{delay: time.Millisecond, seed: defaultSeed, clock: RealClock}
+	for _, opt := range opts {
+		opt(&cfg)
 	}
-	if inputCount != 0 {
-		log.Fatalf("Ошибка: разделение чисел по каналам неверное\n")
+	rnd := rand.New(rand.NewSource(cfg.seed))
+
+	var empty int // пустых опросов подряд в адаптивном режиме
+	for {
+		var v int64
+		var ok bool
+		if cfg.idle != nil {
+			select {
+			case v, ok = <-in:
+				empty = 0
+			default:
+				// канал пуст — пауза по стратегии, обычно дольше прошлой
+				empty++
+				if !sleep(ctx, cfg.clock, cfg.idle.Next(empty)) {
+					return
+				}
+				continue
+			}
+		} else {
+			select {
+			case <-ctx.Done():
+				return
+			case v, ok = <-in:
+			}
+		}
+		if !ok {
+			return
+		}
+		start := cfg.clock.Now()
+		if cfg.stat != nil {
+			cfg.stat.Last, cfg.stat.HasLast = v, true
+		}
+		if cfg.onReceive != nil {
+			cfg.onReceive(v)
+		}
+
+		forward := true
+		switch {
+		case cfg.processErr != nil:
+			var res int64
+			var failed, panicked error
+			if cfg.onPanic != nil {
+				// ошибку самой fn нужно отличать от паники, которую возвращает callSafe
+				_, panicked = callSafe(func(v int64) (int64, error) {
+					res, failed = cfg.processErr(v)
+					return res, nil
+				}, v)
+			} else {
+				res, failed = cfg.processErr(v)
+			}
+			switch {
+			case panicked != nil:
+				cfg.onPanic(v, panicked)
+				forward = false
+			case failed != nil:
+				if cfg.onError != nil {
+					cfg.onError(v, failed)
+				}
+				forward = false
+			}
+			v = res
+		case cfg.process != nil && cfg.onPanic != nil:
+			res, err := callSafe(func(v int64) (int64, error) {
+				return cfg.process(v), nil
+			}, v)
+			if err != nil {
+				cfg.onPanic(v, err)
+				forward = false
+			}
+			v = res
+		case cfg.process != nil:
+			v = cfg.process(v)
+		}
+		if forward {
+			// число уже учтено генератором, поэтому его нужно передать
+			// дальше независимо от состояния контекста
+			out <- v
+		}
+
+		d := cfg.delay
+		if cfg.spread > 0 {
+			d += time.Duration(rnd.Int63n(int64(cfg.spread)))
+		}
+		ok = sleep(ctx, cfg.clock, d)
+		if cfg.stat != nil {
+			cfg.stat.Processed++
+			cfg.stat.Busy += cfg.clock.Now().Sub(start)
+		}
+		if !ok {
+			return
+		}
 	}
 }
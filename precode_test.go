@@ -0,0 +1,198 @@
+package pipeline
+
+import (
+	"context"
+	"errors"
+	"math/rand"
+	"slices"
+	"testing"
+	"time"
+)
+
+func TestWorkerProcessErr(t *testing.T) {
+	errOdd := errors.New("нечётное")
+	in := make(chan int64, 4)
+	for v := range int64(4) {
+		in <- v + 1
+	}
+	close(in)
+
+	var failed []int64
+	out := make(chan int64, 4)
+	Worker(context.Background(), in, out, WithJitter(0, 0), WithProcessErr(func(v int64) (int64, error) {
+		if v%2 == 1 {
+			return 0, errOdd
+		}
+		return 10 * v, nil
+	}, func(v int64, err error) {
+		if !errors.Is(err, errOdd) {
+			t.Errorf("ошибка %v, want %v", err, errOdd)
+		}
+		failed = append(failed, v)
+	}))
+
+	var got []int64
+	for v := range out {
+		got = append(got, v)
+	}
+	if len(got) != 2 || got[0] != 20 || got[1] != 40 {
+		t.Fatalf("out = %v, want [20 40]", got)
+	}
+	if len(failed) != 2 || failed[0] != 1 || failed[1] != 3 {
+		t.Fatalf("ошибки на %v, want [1 3]", failed)
+	}
+}
+
+func TestWorkerJitterRange(t *testing.T) {
+	const (
+		n      = 20
+		base   = time.Millisecond
+		spread = 2 * time.Millisecond
+	)
+	// паузы с seed 7 повторяют последовательность rand с тем же seed
+	rnd := rand.New(rand.NewSource(7))
+	var want time.Duration
+	for range n {
+		want += base + time.Duration(rnd.Int63n(int64(spread)))
+	}
+
+	in := make(chan int64, n)
+	for v := range int64(n) {
+		in <- v
+	}
+	close(in)
+	out := make(chan int64, n)
+	var st WorkerStat
+
+	start := time.Now()
+	Worker(context.Background(), in, out, WithJitter(base, spread), WithSeed(7), WithStat(&st))
+	elapsed := time.Since(start)
+
+	if st.Busy < want || elapsed < want {
+		t.Fatalf("паузы заняли %v (Busy %v), want не меньше %v", elapsed, st.Busy, want)
+	}
+	if limit := n*(base+spread) + 500*time.Millisecond; elapsed > limit {
+		t.Fatalf("паузы заняли %v, want не больше %v", elapsed, limit)
+	}
+}
+
+func TestWorkerJitterHonorsCancel(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	in := make(chan int64, 1)
+	in <- 1
+	out := make(chan int64, 1)
+	time.AfterFunc(20*time.Millisecond, cancel)
+
+	start := time.Now()
+	Worker(ctx, in, out, WithJitter(time.Hour, 0))
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Fatalf("пауза не прервалась отменой: %v", elapsed)
+	}
+	if v := <-out; v != 1 {
+		t.Fatalf("out = %d, want 1", v)
+	}
+}
+
+func TestWorkerAdaptiveDelay(t *testing.T) {
+	const lo, hi = time.Millisecond, 8 * time.Millisecond
+	clock := &recordClock{}
+	// waitPauses ждёт, пока воркер запросит хотя бы n пауз
+	waitPauses := func(n int) {
+		for len(clock.snapshot()) < n {
+			time.Sleep(100 * time.Microsecond)
+		}
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	in := make(chan int64)
+	out := make(chan int64, 1)
+	go Worker(ctx, in, out, WithClock(clock), WithAdaptiveDelay(lo, hi))
+
+	// пока входа нет, пауза растёт до hi
+	waitPauses(6)
+	in <- 7
+	<-out
+	before := len(clock.snapshot())
+	waitPauses(before + 6)
+	cancel()
+	for range out {
+	}
+
+	pauses := clock.snapshot()
+	if !slices.Equal(pauses[:5], []time.Duration{lo, 2 * lo, 4 * lo, hi, hi}) {
+		t.Fatalf("паузы без входа %v, want рост от %v до %v", pauses[:5], lo, hi)
+	}
+	// после числа пауза сбрасывается к lo и снова растёт
+	i := slices.Index(pauses[1:], lo) + 1
+	if i == 0 || i+4 > len(pauses) {
+		t.Fatalf("паузы %v: нет сброса к %v", pauses, lo)
+	}
+	if want := []time.Duration{lo, lo, 2 * lo, 4 * lo}; !slices.Equal(pauses[i:i+4], want) {
+		t.Fatalf("паузы после числа %v, want %v", pauses[i:i+4], want)
+	}
+}
+
+func TestWorkerAdaptiveBackoff(t *testing.T) {
+	clock := &recordClock{}
+	ctx, cancel := context.WithCancel(context.Background())
+	in := make(chan int64)
+	out := make(chan int64)
+	go Worker(ctx, in, out, WithClock(clock),
+		WithAdaptiveBackoff(0, LinearBackoff{Base: time.Millisecond, Step: 2 * time.Millisecond, Max: 5 * time.Millisecond}))
+
+	for len(clock.snapshot()) < 4 {
+		time.Sleep(100 * time.Microsecond)
+	}
+	cancel()
+	for range out {
+	}
+
+	// паузы пустых опросов задаёт стратегия
+	want := []time.Duration{time.Millisecond, 3 * time.Millisecond, 5 * time.Millisecond, 5 * time.Millisecond}
+	if pauses := clock.snapshot(); !slices.Equal(pauses[:4], want) {
+		t.Fatalf("паузы без входа %v, want %v", pauses[:4], want)
+	}
+}
+
+func TestGeneratorNilCallback(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	ch := make(chan int64)
+	go Generator(ctx, ch, nil)
+	for want := int64(1); want <= 10; want++ {
+		if v := <-ch; v != want {
+			t.Fatalf("пришло %d, want %d", v, want)
+		}
+	}
+	cancel()
+	for range ch {
+	}
+}
+
+func TestWorkerLastAfterCancel(t *testing.T) {
+	const workers = 4
+	p := NewPipeline(workers, WithWorkerStats(), WithPoolProcess(func(v int64) int64 {
+		time.Sleep(time.Millisecond)
+		return v
+	}))
+
+	// воркеры останавливаются вместе с генератором, посреди обработки
+	ctx, cancel := context.WithCancel(context.Background())
+	time.AfterFunc(20*time.Millisecond, cancel)
+	s := p.RunStages(ctx, ctx)
+
+	if len(s.Workers) != workers {
+		t.Fatalf("Workers из %d элементов, want %d", len(s.Workers), workers)
+	}
+	// Stats читается после завершения воркеров, поэтому Last уже не меняется
+	for i, w := range s.Workers {
+		if !w.HasLast {
+			t.Fatalf("воркер %d не взял ни одного числа", i)
+		}
+		if w.Last < 1 || w.Last > s.InputCount {
+			t.Fatalf("воркер %d: Last = %d, want от 1 до %d", i, w.Last, s.InputCount)
+		}
+	}
+	if err := CheckInvariants(s); err != nil {
+		t.Fatal(err)
+	}
+}
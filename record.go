@@ -0,0 +1,120 @@
+package pipeline
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+)
+
+// Формат записи: заголовок recordMagic, за которым идут числа в порядке
+// генерации, каждое — знаковый varint (binary.AppendVarint). Длина записи
+// не хранится: запись кончается вместе с потоком, поэтому её можно
+// дописывать, не зная заранее количества чисел.
+const recordMagic = "P9REC1\n"
+
+// ErrBadRecording возвращается ReplaySource, если запись повреждена или
+// оборвана.
+var ErrBadRecording = errors.New("повреждённая запись чисел")
+
+// Recorder записывает сгенерированные числа в поток, чтобы позже
+// воспроизвести их через ReplaySource и WithSource (см. WithRecorder).
+// Запись буферизуется; первая ошибка записи запоминается, и дальнейшие
+// числа отбрасываются. Recorder не безопасен для одновременного вызова
+// из нескольких горутин.
+type Recorder struct {
+	w      *bufio.Writer
+	buf    []byte
+	header bool
+	err    error
+}
+
+// NewRecorder создаёт Recorder, пишущий в w.
+func NewRecorder(w io.Writer) *Recorder {
+	return &Recorder{w: bufio.NewWriter(w)}
+}
+
+// Add записывает число v.
+func (r *Recorder) Add(v int64) {
+	if r.err != nil {
+		return
+	}
+	if !r.header {
+		r.header = true
+		if _, r.err = r.w.WriteString(recordMagic); r.err != nil {
+			return
+		}
+	}
+	r.buf = binary.AppendVarint(r.buf[:0], v)
+	_, r.err = r.w.Write(r.buf)
+}
+
+// Flush дописывает буферизованные числа в поток и возвращает первую
+// ошибку записи, если она была. Пустая запись тоже получает заголовок,
+// чтобы ReplaySource отличал её от постороннего файла.
+func (r *Recorder) Flush() error {
+	if r.err == nil && !r.header {
+		r.header = true
+		_, r.err = r.w.WriteString(recordMagic)
+	}
+	if r.err == nil {
+		r.err = r.w.Flush()
+	}
+	return r.err
+}
+
+// ReplaySource читает запись, сделанную Recorder, и возвращает числа в
+// исходном порядке для WithSource. Если запись оборвана или повреждена,
+// возвращаются числа, прочитанные до этого места, и ошибка, оборачивающая
+// ErrBadRecording, так что уцелевшую часть всё ещё можно воспроизвести.
+// Ошибки чтения самого r возвращаются обёрнутыми, без ErrBadRecording:
+// запись при этом может быть цела.
+func ReplaySource(r io.Reader) ([]int64, error) {
+	er := &errReader{r: r}
+	br := bufio.NewReader(er)
+
+	magic := make([]byte, len(recordMagic))
+	if _, err := io.ReadFull(br, magic); err != nil {
+		if er.err == nil && (errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF)) {
+			return nil, fmt.Errorf("%w: нет заголовка", ErrBadRecording)
+		}
+		return nil, err
+	}
+	if string(magic) != recordMagic {
+		return nil, fmt.Errorf("%w: неизвестный заголовок %q", ErrBadRecording, magic)
+	}
+
+	values := []int64{}
+	for {
+		v, err := binary.ReadVarint(br)
+		switch {
+		case err == nil:
+			values = append(values, v)
+		case er.err != nil:
+			return values, fmt.Errorf("число %d: %w", len(values)+1, err)
+		case errors.Is(err, io.EOF):
+			return values, nil
+		case errors.Is(err, io.ErrUnexpectedEOF):
+			return values, fmt.Errorf("%w: запись оборвана после %d чисел", ErrBadRecording, len(values))
+		default:
+			// переполнение varint
+			return values, fmt.Errorf("%w: число %d: %v", ErrBadRecording, len(values)+1, err)
+		}
+	}
+}
+
+// errReader запоминает ошибку чтения r, кроме io.EOF, чтобы ReplaySource
+// мог отличить сбой чтения от испорченной записи.
+type errReader struct {
+	r   io.Reader
+	err error
+}
+
+func (e *errReader) Read(p []byte) (int, error) {
+	n, err := e.r.Read(p)
+	if err != nil && err != io.EOF && e.err == nil {
+		e.err = err
+	}
+	return n, err
+}
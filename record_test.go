@@ -0,0 +1,82 @@
+package pipeline
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"slices"
+	"strings"
+	"testing"
+	"testing/iotest"
+	"time"
+)
+
+func TestRecordAndReplayRun(t *testing.T) {
+	var buf bytes.Buffer
+	first, err := NewPipeline(3, WithRecorder(NewRecorder(&buf))).RunFor(context.Background(), 20*time.Millisecond)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	values, err := ReplaySource(bytes.NewReader(buf.Bytes()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	second := NewPipeline(3, WithSource(values)).Run(context.Background())
+	if second.InputCount != first.InputCount || second.InputSum != first.InputSum {
+		t.Fatalf("повтор: InputCount = %d, InputSum = %d, want %d, %d",
+			second.InputCount, second.InputSum, first.InputCount, first.InputSum)
+	}
+	if err := CheckInvariants(second); err != nil {
+		t.Fatal(err)
+	}
+
+}
+
+func TestReplaySourceErrors(t *testing.T) {
+	var buf bytes.Buffer
+	rec := NewRecorder(&buf)
+	for v := int64(1); v <= 5; v++ {
+		rec.Add(v)
+	}
+	if err := rec.Flush(); err != nil {
+		t.Fatal(err)
+	}
+	good := buf.Bytes()
+	errRead := errors.New("сбой чтения")
+
+	tests := []struct {
+		name    string
+		r       io.Reader
+		bad     bool  // ошибка оборачивает ErrBadRecording
+		wrapped error // ошибка оборачивает wrapped
+	}{
+		{"no header", bytes.NewReader(nil), true, nil},
+		{"bad header", strings.NewReader("nope, not a recording"), true, nil},
+		{"overflow", bytes.NewReader(append(slices.Clone(good[:len(recordMagic)]), bytes.Repeat([]byte{0xff}, 11)...)), true, nil},
+		{"read error", io.MultiReader(bytes.NewReader(good), iotest.ErrReader(errRead)), false, errRead},
+		{"read unexpected EOF", io.MultiReader(bytes.NewReader(good), iotest.ErrReader(io.ErrUnexpectedEOF)), false, io.ErrUnexpectedEOF},
+		{"header read error", iotest.ErrReader(errRead), false, errRead},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := ReplaySource(tt.r)
+			if err == nil {
+				t.Fatal("ReplaySource без ошибки")
+			}
+			if errors.Is(err, ErrBadRecording) != tt.bad {
+				t.Fatalf("ReplaySource = %v, want ErrBadRecording: %v", err, tt.bad)
+			}
+			if tt.wrapped != nil && !errors.Is(err, tt.wrapped) {
+				t.Fatalf("ReplaySource = %v, want обёртку %v", err, tt.wrapped)
+			}
+		})
+	}
+
+	// числа до сбоя чтения не теряются
+	values, err := ReplaySource(io.MultiReader(bytes.NewReader(good), iotest.ErrReader(errRead)))
+	if !errors.Is(err, errRead) || !slices.Equal(values, []int64{1, 2, 3, 4, 5}) {
+		t.Fatalf("ReplaySource = %v, %v", values, err)
+	}
+}
@@ -0,0 +1,30 @@
+package pipeline
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+)
+
+// runIDKey — ключ идентификатора запуска в контексте.
+type runIDKey struct{}
+
+// WithRunID возвращает контекст с идентификатором запуска id. Конвейер,
+// запущенный с таким контекстом, добавляет id во все записи лога и в
+// Stats.RunID.
+func WithRunID(ctx context.Context, id string) context.Context {
+	return context.WithValue(ctx, runIDKey{}, id)
+}
+
+// RunIDFrom возвращает идентификатор запуска из контекста ctx.
+func RunIDFrom(ctx context.Context) (string, bool) {
+	id, ok := ctx.Value(runIDKey{}).(string)
+	return id, ok
+}
+
+// newRunID генерирует случайный идентификатор запуска.
+func newRunID() string {
+	var b [8]byte
+	rand.Read(b[:])
+	return hex.EncodeToString(b[:])
+}
@@ -0,0 +1,58 @@
+package pipeline
+
+import (
+	"bufio"
+	"io"
+	"strconv"
+	"sync"
+)
+
+// LineSink пишет числа в поток по одному в строке, см. WriterSink.
+type LineSink struct {
+	mu  sync.Mutex
+	w   *bufio.Writer
+	buf []byte
+	err error
+}
+
+// WriterSink возвращает приёмник, который пишет каждое число в w
+// отдельной строкой — в файл или сокет. Его метод Add подходит для
+// WithSink и безопасен при нескольких потребителях (WithConsumers).
+// Запись буферизуется, поэтому после запуска нужно вызвать Close.
+func WriterSink(w io.Writer) *LineSink {
+	return &LineSink{w: bufio.NewWriter(w)}
+}
+
+// Add записывает число v. После первой ошибки записи числа отбрасываются,
+// а ошибку возвращают Err и Close.
+func (s *LineSink) Add(v int64) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.err != nil {
+		return
+	}
+	s.buf = strconv.AppendInt(s.buf[:0], v, 10)
+	s.buf = append(s.buf, '\n')
+	_, s.err = s.w.Write(s.buf)
+}
+
+// Err возвращает первую ошибку записи или nil.
+func (s *LineSink) Err() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.err
+}
+
+// Close дописывает буферизованные строки в поток и возвращает первую
+// ошибку записи. Сам поток не закрывается.
+func (s *LineSink) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.err == nil {
+		s.err = s.w.Flush()
+	}
+	return s.err
+}
@@ -0,0 +1,23 @@
+package pipeline
+
+import (
+	"bytes"
+	"context"
+	"testing"
+)
+
+func TestWriterSink(t *testing.T) {
+	var buf bytes.Buffer
+	sink := WriterSink(&buf)
+	s := NewPipeline(1, WithDeterministic(), WithTake(5), WithSink(sink.Add),
+		WithPoolProcess(func(v int64) int64 { return 2 * v })).Run(context.Background())
+	if err := sink.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if got, want := buf.String(), "2\n4\n6\n8\n10\n"; got != want {
+		t.Fatalf("записано %q, want %q", got, want)
+	}
+	if err := CheckInvariants(s); err != nil {
+		t.Fatal(err)
+	}
+}
@@ -0,0 +1,110 @@
+package pipeline
+
+import (
+	"bufio"
+	"io"
+	"math/rand"
+	"strconv"
+	"strings"
+	"sync/atomic"
+)
+
+// Source — внешний источник чисел для конвейера, см. WithInputSource.
+type Source interface {
+	// Next возвращает очередное число; false означает, что числа кончились.
+	Next() (int64, bool)
+}
+
+// LineSource — Source, читающий числа по одному в строке, см. ReaderSource.
+type LineSource struct {
+	sc      *bufio.Scanner
+	skipped atomic.Int64
+	err     error
+}
+
+// ReaderSource возвращает Source, читающий из r числа int64 по одному в
+// строке — из файла, стандартного ввода или сетевого соединения. Пробелы
+// по краям строки и пустые строки пропускаются; строки, которые не
+// удалось разобрать как int64, тоже пропускаются и считаются в Skipped.
+// Числа кончаются в конце r или при ошибке чтения, которую возвращает Err.
+func ReaderSource(r io.Reader) *LineSource {
+	return &LineSource{sc: bufio.NewScanner(r)}
+}
+
+// Next возвращает очередное число из потока.
+func (s *LineSource) Next() (int64, bool) {
+	for s.sc.Scan() {
+		line := strings.TrimSpace(s.sc.Text())
+		if line == "" {
+			continue
+		}
+		v, err := strconv.ParseInt(line, 10, 64)
+		if err != nil {
+			s.skipped.Add(1)
+			continue
+		}
+		return v, true
+	}
+	s.err = s.sc.Err()
+	return 0, false
+}
+
+// Skipped возвращает количество пропущенных неверных строк. Его можно
+// читать, пока источник используется.
+func (s *LineSource) Skipped() int64 {
+	return s.skipped.Load()
+}
+
+// Err возвращает ошибку чтения потока, на которой кончились числа, или
+// nil, если поток дочитан до конца. Её нужно читать после того, как Next
+// вернул false.
+func (s *LineSource) Err() error {
+	return s.err
+}
+
+// RandSource — Source псевдослучайных чисел, см. RandomSource.
+type RandSource struct {
+	rnd   *rand.Rand
+	limit int64
+}
+
+// RandomSource возвращает бесконечный Source псевдослучайных чисел из
+// [0, limit) — для нагрузки, не похожей на последовательность 1, 2, 3 и т.д.
+// Числа задаются начальным значением seed: два источника с одним seed
+// выдают одинаковые последовательности, поэтому запуск воспроизводим.
+// limit меньше 1 считается равным 1. Числа повторяются, поэтому задержки
+// WithLatency у одинаковых чисел могут перепутаться (см. WithSource).
+// Запуск с таким источником длится, пока его не остановят, например
+// через RunFor или WithTake.
+func RandomSource(seed, limit int64) *RandSource {
+	return &RandSource{rnd: rand.New(rand.NewSource(seed)), limit: max(limit, 1)}
+}
+
+// Next возвращает очередное число; числа не кончаются.
+func (s *RandSource) Next() (int64, bool) {
+	return s.rnd.Int63n(s.limit), true
+}
+
+// heldSource — обёртка Source для WithInputSource, которой генератор
+// возвращает взятое, но не отправленное число: следующий вызов next
+// выдаёт его первым. Методы вызываются только из горутины генератора.
+type heldSource struct {
+	src  Source
+	held int64
+	ok   bool
+}
+
+// next возвращает возвращённое число, если оно есть, иначе — очередное
+// число src.
+func (h *heldSource) next() (int64, bool) {
+	if h.ok {
+		h.ok = false
+		return h.held, true
+	}
+	return h.src.Next()
+}
+
+// unread возвращает число v, чтобы next выдал его снова.
+func (h *heldSource) unread(v int64) {
+	h.held, h.ok = v, true
+}
@@ -0,0 +1,109 @@
+package pipeline
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestReaderSource(t *testing.T) {
+	src := ReaderSource(strings.NewReader("1\n x\n\n 2 \n3.5\n-4\n"))
+
+	var got []int64
+	for {
+		v, ok := src.Next()
+		if !ok {
+			break
+		}
+		got = append(got, v)
+	}
+	if fmt.Sprint(got) != "[1 2 -4]" {
+		t.Fatalf("числа %v, want [1 2 -4]", got)
+	}
+	if src.Skipped() != 2 {
+		t.Fatalf("Skipped = %d, want 2", src.Skipped())
+	}
+	if src.Err() != nil {
+		t.Fatal(src.Err())
+	}
+}
+
+func TestReaderSourcePipeline(t *testing.T) {
+	src := ReaderSource(strings.NewReader("1\n x\n\n 2 \n3.5\n-4\n"))
+	s := NewPipeline(2, WithInputSource(src), WithGapDetection()).Run(context.Background())
+	if s.InputCount != 3 || s.InputSum != -1 || s.StopReason != nil {
+		t.Fatalf("InputCount = %d, InputSum = %d, StopReason = %v", s.InputCount, s.InputSum, s.StopReason)
+	}
+	if err := CheckInvariants(s); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestInputSourceResumes(t *testing.T) {
+	var lines strings.Builder
+	for i := 1; i <= 100000; i++ {
+		fmt.Fprintln(&lines, i)
+	}
+
+	tests := []struct {
+		name string
+		opts []Option
+	}{
+		{"plain", nil},
+		{"latency", []Option{WithLatency()}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			src := ReaderSource(strings.NewReader(lines.String()))
+			// медленный воркер держит генератор на отправке, когда истекает время
+			opts := append([]Option{WithInputSource(src), WithWorkerOptions(WithJitter(2*time.Millisecond, 0))}, tt.opts...)
+			p := NewPipeline(1, opts...)
+
+			var next int64 = 1
+			for run := range 3 {
+				s, err := p.RunFor(context.Background(), 20*time.Millisecond)
+				if err != nil {
+					t.Fatal(err)
+				}
+				// числа запуска — next, next+1, ..., next+InputCount-1
+				n := s.InputCount
+				if want := n*next + n*(n-1)/2; s.InputSum != want {
+					t.Fatalf("запуск %d: InputSum = %d, want %d: числа начались не с %d", run, s.InputSum, want, next)
+				}
+				next += n
+			}
+		})
+	}
+}
+
+func TestRandomSourceSeed(t *testing.T) {
+	const limit = 1000
+	a, b, other := RandomSource(42, limit), RandomSource(42, limit), RandomSource(43, limit)
+	same := true
+	for i := range 500 {
+		va, _ := a.Next()
+		vb, ok := b.Next()
+		if !ok || va != vb {
+			t.Fatalf("число %d: %d и %d у одного seed", i, va, vb)
+		}
+		if va < 0 || va >= limit {
+			t.Fatalf("число %d = %d, want в [0, %d)", i, va, limit)
+		}
+		if vo, _ := other.Next(); vo != va {
+			same = false
+		}
+	}
+	if same {
+		t.Fatal("другой seed дал ту же последовательность")
+	}
+
+	// одинаковые источники дают одинаковые запуски
+	run := func() Stats {
+		return NewPipeline(3, WithInputSource(RandomSource(7, limit)), WithTake(200)).Run(context.Background())
+	}
+	if s1, s2 := run(), run(); s1.InputSum != s2.InputSum || s1.OutputSum != s2.OutputSum || s1.InputCount != 200 {
+		t.Fatalf("запуски с одним seed: %d/%d и %d/%d", s1.InputCount, s1.InputSum, s2.InputCount, s2.InputSum)
+	}
+}
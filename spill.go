@@ -0,0 +1,178 @@
+package pipeline
+
+import (
+	"bufio"
+	"context"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"os"
+)
+
+// SpillStats — статистика SpillStage.
+type SpillStats struct {
+	Spilled      int64 // сколько чисел было сброшено на диск
+	BytesWritten int64 // сколько байт записано во временный файл
+}
+
+// SpillStage работает как BufferStage, но не блокирует источник, когда
+// буфер в памяти на limit чисел заполнен: остальные числа сбрасываются
+// во временный файл в каталоге dir (пустой dir — системный каталог
+// временных файлов) и читаются обратно, как только получатель разберёт
+// буфер. Порядок чисел сохраняется.
+//
+// Каждое число записывается как байт длины и следом число в формате
+// varint (binary.PutVarint), так что запись занимает от 2 до 11 байт.
+// Прочитанные записи из файла не вырезаются, и он растёт до конца работы
+// стадии.
+//
+// Функция result ждёт завершения стадии и возвращает её статистику и
+// первую ошибку ввода-вывода. При ошибке или отмене ctx выходной канал
+// закрывается, а недоставленные числа теряются; с опцией FlushOnCancel
+// при отмене сначала отдаются числа из памяти и из файла. Временный файл
+// удаляется в любом случае.
+func SpillStage(ctx context.Context, in <-chan int64, limit int, dir string, opts ...StageOption) (out <-chan int64, result func() (SpillStats, error)) {
+	if limit < 1 {
+		limit = 1
+	}
+	var cfg stageConfig
+	for _, opt := range opts {
+		opt(&cfg)
+	}
+	ch := make(chan int64)
+	done := make(chan struct{})
+	var (
+		stats SpillStats
+		err   error
+	)
+
+	go func() {
+		defer close(done)
+		defer close(ch)
+
+		f, e := os.CreateTemp(dir, "spill-*.bin")
+		if e != nil {
+			err = e
+			return
+		}
+		defer os.Remove(f.Name())
+		defer f.Close()
+
+		sp := spillFile{f: f, w: bufio.NewWriter(f)}
+		mem := make([]int64, 0, limit)
+		for in != nil || len(mem) > 0 || sp.pending > 0 {
+			if len(mem) == 0 && sp.pending > 0 {
+				if mem, err = sp.read(mem, limit); err != nil {
+					return
+				}
+			}
+
+			var dst chan<- int64
+			var next int64
+			if len(mem) > 0 {
+				dst = ch
+				next = mem[0]
+			}
+
+			select {
+			case <-ctx.Done():
+				if cfg.flushOnCancel {
+					err = sp.flush(ch, mem, limit)
+				}
+				return
+			case v, ok := <-in:
+				if !ok {
+					in = nil
+					continue
+				}
+				// после начала сброса все числа идут в файл, иначе
+				// нарушится порядок
+				if sp.pending == 0 && len(mem) < limit {
+					mem = append(mem, v)
+					continue
+				}
+				if err = sp.write(v); err != nil {
+					return
+				}
+				stats.Spilled++
+				stats.BytesWritten = sp.written
+			case dst <- next:
+				mem = mem[1:]
+			}
+		}
+	}()
+
+	result = func() (SpillStats, error) {
+		<-done
+		return stats, err
+	}
+	return ch, result
+}
+
+// spillFile — очередь чисел во временном файле: запись идёт в конец,
+// чтение — с позиции off.
+type spillFile struct {
+	f       *os.File
+	w       *bufio.Writer
+	off     int64 // откуда читать следующую запись
+	written int64 // сколько байт записано
+	pending int64 // сколько записей ещё не прочитано
+}
+
+// write добавляет v в конец файла.
+func (s *spillFile) write(v int64) error {
+	var buf [1 + binary.MaxVarintLen64]byte
+	n := binary.PutVarint(buf[1:], v)
+	buf[0] = byte(n)
+	if _, err := s.w.Write(buf[:1+n]); err != nil {
+		return fmt.Errorf("запись во временный файл: %w", err)
+	}
+	s.written += int64(1 + n)
+	s.pending++
+	return nil
+}
+
+// flush отправляет в ch числа mem, а затем все непрочитанные записи файла.
+func (s *spillFile) flush(ch chan<- int64, mem []int64, limit int) error {
+	for {
+		for _, v := range mem {
+			ch <- v
+		}
+		if s.pending == 0 {
+			return nil
+		}
+		var err error
+		if mem, err = s.read(mem[:0], limit); err != nil {
+			return err
+		}
+	}
+}
+
+// read дочитывает в mem до limit записей.
+func (s *spillFile) read(mem []int64, limit int) ([]int64, error) {
+	if err := s.w.Flush(); err != nil {
+		return mem, fmt.Errorf("запись во временный файл: %w", err)
+	}
+	r := bufio.NewReader(io.NewSectionReader(s.f, s.off, s.written-s.off))
+	for len(mem) < limit && s.pending > 0 {
+		n, err := r.ReadByte()
+		if err != nil {
+			return mem, fmt.Errorf("чтение временного файла: %w", err)
+		}
+		var buf [binary.MaxVarintLen64]byte
+		if int(n) > len(buf) {
+			return mem, fmt.Errorf("чтение временного файла: неверная длина записи %d", n)
+		}
+		if _, err := io.ReadFull(r, buf[:n]); err != nil {
+			return mem, fmt.Errorf("чтение временного файла: %w", err)
+		}
+		v, m := binary.Varint(buf[:n])
+		if m != int(n) {
+			return mem, fmt.Errorf("чтение временного файла: повреждённая запись")
+		}
+		mem = append(mem, v)
+		s.off += int64(1 + n)
+		s.pending--
+	}
+	return mem, nil
+}
@@ -0,0 +1,40 @@
+package pipeline
+
+import (
+	"context"
+	"math"
+	"os"
+	"slices"
+	"testing"
+	"time"
+)
+
+func TestSpillStageNoLoss(t *testing.T) {
+	want := make([]int64, 300)
+	for i := range want {
+		want[i] = int64(i) * 7919
+	}
+	want[10], want[20] = math.MaxInt64, math.MinInt64
+
+	dir := t.TempDir()
+	out, result := SpillStage(context.Background(), Replay(context.Background(), want), 8, dir)
+	var got []int64
+	for v := range out {
+		// медленный получатель: источник успевает уйти далеко вперёд
+		time.Sleep(20 * time.Microsecond)
+		got = append(got, v)
+	}
+	stats, err := result()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !slices.Equal(got, want) {
+		t.Fatalf("получено %d чисел не по порядку или с потерями", len(got))
+	}
+	if stats.Spilled == 0 || stats.BytesWritten < 2*stats.Spilled {
+		t.Fatalf("SpillStats = %+v, want сброс на диск", stats)
+	}
+	if files, _ := os.ReadDir(dir); len(files) != 0 {
+		t.Fatalf("временный файл не удалён: %v", files)
+	}
+}
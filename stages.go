@@ -0,0 +1,792 @@
+package pipeline
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math/rand"
+	"time"
+)
+
+// Record пропускает числа из in в возвращаемый канал, попутно записывая
+// их. Функция values ждёт закрытия in и возвращает записанные числа
+// в порядке поступления; их можно затем повторить через Replay.
+// Записанное возвращается функцией, а не готовым []int64: в момент вызова
+// Record чисел ещё нет, а слайс, отданный сразу, не увидел бы добавленных
+// позже элементов, и читать его до закрытия in было бы гонкой.
+func Record(in <-chan int64) (values func() []int64, out <-chan int64) {
+	ch := make(chan int64)
+	done := make(chan struct{})
+	var rec []int64
+
+	go func() {
+		defer close(done)
+		defer close(ch)
+		for v := range in {
+			rec = append(rec, v)
+			ch <- v
+		}
+	}()
+
+	values = func() []int64 {
+		<-done
+		return rec
+	}
+	return values, ch
+}
+
+// Replay отправляет числа values в возвращаемый канал в том же порядке
+// и закрывает его. Отправка прекращается при отмене контекста ctx.
+func Replay(ctx context.Context, values []int64) <-chan int64 {
+	ch := make(chan int64)
+
+	go func() {
+		defer close(ch)
+		for _, v := range values {
+			select {
+			case <-ctx.Done():
+				return
+			case ch <- v:
+			}
+		}
+	}()
+
+	return ch
+}
+
+// Tee передаёт каждое число из in во все каналы outs и закрывает их
+// после закрытия in. Значения не теряются, но отправка идёт по очереди,
+// поэтому медленный получатель задерживает всех остальных: следующее
+// число не будет отправлено никому, пока текущее не примут все.
+// Для развязки получателей используйте буферизованные каналы outs.
+func Tee(in <-chan int64, outs ...chan<- int64) {
+	defer func() {
+		for _, out := range outs {
+			close(out)
+		}
+	}()
+
+	for v := range in {
+		for _, out := range outs {
+			out <- v
+		}
+	}
+}
+
+// TeeN передаёт каждое значение из in во все n возвращаемых каналов
+// (n меньше 1 считается равным 1) в одном и том же порядке. У каждого
+// выхода свой буфер без ограничения размера, поэтому получатели читают в
+// своём темпе и медленный не задерживает остальных; платой служит память
+// под значения, которые медленный получатель ещё не забрал. Выходы
+// закрываются, когда in закрыт и получатель забрал всё из буфера, либо
+// сразу при отмене ctx.
+func TeeN[T any](ctx context.Context, in <-chan T, n int) []<-chan T {
+	n = max(n, 1)
+	feeds := make([]chan T, n)
+	outs := make([]<-chan T, n)
+	for i := range feeds {
+		feeds[i] = make(chan T)
+		outs[i] = pump(ctx, feeds[i])
+	}
+
+	go func() {
+		defer func() {
+			for _, feed := range feeds {
+				close(feed)
+			}
+		}()
+
+		for {
+			var v T
+			select {
+			case <-ctx.Done():
+				return
+			case x, ok := <-in:
+				if !ok {
+					return
+				}
+				v = x
+			}
+			for _, feed := range feeds {
+				// pump всегда готов принять значение, пока не отменён ctx
+				select {
+				case <-ctx.Done():
+					return
+				case feed <- v:
+				}
+			}
+		}
+	}()
+
+	return outs
+}
+
+// pump отдаёт значения из in в возвращаемый канал, копя их в буфере без
+// ограничения, пока получатель не заберёт. Канал закрывается, когда in
+// закрыт и буфер пуст, или при отмене ctx.
+func pump[T any](ctx context.Context, in <-chan T) <-chan T {
+	out := make(chan T)
+
+	go func() {
+		defer close(out)
+
+		var buf []T
+		for in != nil || len(buf) > 0 {
+			// nil-каналы выключают соответствующие ветки select
+			var dst chan<- T
+			var next T
+			if len(buf) > 0 {
+				dst = out
+				next = buf[0]
+			}
+
+			select {
+			case <-ctx.Done():
+				return
+			case v, ok := <-in:
+				if !ok {
+					in = nil
+					continue
+				}
+				buf = append(buf, v)
+			case dst <- next:
+				var zero T
+				buf[0] = zero
+				buf = buf[1:]
+			}
+		}
+	}()
+
+	return out
+}
+
+// StageOption настраивает буферизующие стадии, например BufferStage и Batch.
+type StageOption func(*stageConfig)
+
+// stageConfig — параметры буферизующей стадии.
+type stageConfig struct {
+	flushOnCancel bool  // отдать содержимое буфера при отмене контекста
+	clock         Clock // источник времени для стадий, которые ждут
+}
+
+// newStageConfig применяет opts к параметрам по умолчанию.
+func newStageConfig(opts []StageOption) stageConfig {
+	cfg := stageConfig{clock: RealClock}
+	for _, opt := range opts {
+		opt(&cfg)
+	}
+	return cfg
+}
+
+// StageClock задаёт источник времени стадии, например для отправки
+// неполных пачек Batch. По умолчанию используется RealClock.
+func StageClock(c Clock) StageOption {
+	return func(cfg *stageConfig) {
+		cfg.clock = c
+	}
+}
+
+// FlushOnCancel заставляет стадию при отмене контекста отдать всё, что
+// накопилось в буфере, и только потом закрыть выходной канал, чтобы при
+// плавной остановке ничего не терялось. Отдача после отмены ждёт
+// получателя, поэтому выходной канал нужно дочитать до закрытия.
+func FlushOnCancel() StageOption {
+	return func(c *stageConfig) {
+		c.flushOnCancel = true
+	}
+}
+
+// BufferStage развязывает источник in и получателя: числа из in копятся
+// во внутреннем буфере до limit штук и отдаются в возвращаемый канал
+// в порядке поступления. Когда буфер заполнен, BufferStage перестаёт
+// читать in, и источник блокируется, пока получатель не заберёт хотя бы
+// одно число. Значение limit меньше 1 считается равным 1.
+//
+// Выходной канал закрывается, когда in закрыт и буфер пуст, либо при
+// отмене контекста ctx — в этом случае содержимое буфера отбрасывается,
+// если не задана опция FlushOnCancel.
+func BufferStage(ctx context.Context, in <-chan int64, limit int, opts ...StageOption) <-chan int64 {
+	if limit < 1 {
+		limit = 1
+	}
+	cfg := newStageConfig(opts)
+	out := make(chan int64)
+
+	go func() {
+		defer close(out)
+
+		buf := make([]int64, 0, limit)
+		for in != nil || len(buf) > 0 {
+			// nil-каналы выключают соответствующие ветки select
+			var src <-chan int64
+			if len(buf) < limit {
+				src = in
+			}
+			var dst chan<- int64
+			var next int64
+			if len(buf) > 0 {
+				dst = out
+				next = buf[0]
+			}
+
+			select {
+			case <-ctx.Done():
+				if cfg.flushOnCancel {
+					for _, v := range buf {
+						out <- v
+					}
+				}
+				return
+			case v, ok := <-src:
+				if !ok {
+					in = nil
+					continue
+				}
+				buf = append(buf, v)
+			case dst <- next:
+				buf = buf[1:]
+			}
+		}
+	}()
+
+	return out
+}
+
+// Batch собирает числа из in в пачки по size штук и отправляет их в
+// возвращаемый канал. Неполная пачка отправляется, если за every по часам
+// стадии (см. StageClock) она не заполнилась, чтобы редкие числа не
+// застревали; пустые пачки не отправляются. size меньше 1 считается
+// равным 1, every не больше нуля отключает отправку по времени.
+//
+// После закрытия in остаток отправляется, и канал закрывается. При отмене
+// ctx канал закрывается сразу, а остаток отбрасывается, если не задана
+// опция FlushOnCancel.
+func Batch(ctx context.Context, in <-chan int64, size int, every time.Duration, opts ...StageOption) <-chan []int64 {
+	size = max(size, 1)
+	cfg := newStageConfig(opts)
+	out := make(chan []int64)
+
+	go func() {
+		defer close(out)
+
+		var tick <-chan time.Time
+		if every > 0 {
+			t := cfg.clock.NewTicker(every)
+			defer t.Stop()
+			tick = t.C()
+		}
+		batch := make([]int64, 0, size)
+		// cancelled отдаёт остаток при FlushOnCancel
+		cancelled := func() {
+			if cfg.flushOnCancel && len(batch) > 0 {
+				out <- batch
+			}
+		}
+		// flush отправляет накопленную пачку; false — ctx отменён раньше
+		flush := func() bool {
+			if len(batch) == 0 {
+				return true
+			}
+			select {
+			case <-ctx.Done():
+				cancelled()
+				return false
+			case out <- batch:
+				batch = make([]int64, 0, size)
+				return true
+			}
+		}
+
+		for {
+			select {
+			case <-ctx.Done():
+				cancelled()
+				return
+			case v, ok := <-in:
+				if !ok {
+					flush()
+					return
+				}
+				batch = append(batch, v)
+				if len(batch) == size && !flush() {
+					return
+				}
+			case <-tick:
+				if !flush() {
+					return
+				}
+			}
+		}
+	}()
+
+	return out
+}
+
+// Filter передаёт из in в out только числа, для которых pred возвращает
+// true, и закрывает out после закрытия in. Возвращает количество
+// отброшенных чисел.
+func Filter(in <-chan int64, out chan<- int64, pred func(int64) bool) (dropped int64) {
+	defer close(out)
+
+	for v := range in {
+		if !pred(v) {
+			dropped++
+			continue
+		}
+		out <- v
+	}
+	return dropped
+}
+
+// Dedup передаёт из in в out только первое появление каждого числа и
+// закрывает out после закрытия in. Возвращает количество отброшенных
+// повторов.
+//
+// При window == 0 Dedup помнит все встреченные числа, и повторы
+// отбрасываются точно, но память растёт с каждым новым числом. При
+// window > 0 помнятся только последние window различных чисел: память
+// ограничена, а повтор числа, вытесненного из окна, пропускается снова.
+func Dedup(in <-chan int64, out chan<- int64, window int) (dropped int64) {
+	defer close(out)
+
+	seen := make(map[int64]struct{})
+	// ring — последние window различных чисел в порядке появления
+	var ring []int64
+	if window > 0 {
+		ring = make([]int64, 0, window)
+	}
+	next := 0
+
+	for v := range in {
+		if _, dup := seen[v]; dup {
+			dropped++
+			continue
+		}
+		seen[v] = struct{}{}
+		if window > 0 {
+			if len(ring) < window {
+				ring = append(ring, v)
+			} else {
+				delete(seen, ring[next])
+				ring[next] = v
+				next = (next + 1) % window
+			}
+		}
+		out <- v
+	}
+	return dropped
+}
+
+// Compact сворачивает подряд идущие одинаковые числа из in в одно и
+// отправляет результат в возвращаемый канал. В отличие от Dedup он
+// сравнивает число только с предыдущим, поэтому память постоянна, а
+// повтор, отделённый другим числом, проходит снова: 1, 1, 2, 1 дают 1, 2, 1.
+// Удобен после Transform, округляющего числа. Канал закрывается после
+// закрытия in или отмены ctx.
+func Compact(ctx context.Context, in <-chan int64) <-chan int64 {
+	out := make(chan int64)
+
+	go func() {
+		defer close(out)
+
+		var prev int64
+		first := true
+		for {
+			var v int64
+			select {
+			case <-ctx.Done():
+				return
+			case x, ok := <-in:
+				if !ok {
+					return
+				}
+				v = x
+			}
+			if !first && v == prev {
+				continue
+			}
+			first, prev = false, v
+
+			select {
+			case <-ctx.Done():
+				return
+			case out <- v:
+			}
+		}
+	}()
+
+	return out
+}
+
+// Sample передаёт из in в out каждое число с вероятностью rate и
+// закрывает out после закрытия in. rate не больше 0 не пропускает ничего,
+// не меньше 1 — пропускает всё. Решения принимает генератор случайных
+// чисел с зерном seed, поэтому при одном и том же seed и одном и том же
+// потоке отбираются одни и те же числа. Возвращает, сколько чисел
+// прочитано из in и сколько передано в out.
+func Sample(in <-chan int64, out chan<- int64, rate float64, seed int64) (seen, forwarded int64) {
+	defer close(out)
+
+	rnd := rand.New(rand.NewSource(seed))
+	for v := range in {
+		seen++
+		if rnd.Float64() >= rate {
+			continue
+		}
+		forwarded++
+		out <- v
+	}
+	return seen, forwarded
+}
+
+// Split направляет каждое число из in в matched, если pred возвращает
+// true, и в unmatched — иначе. Каждое число попадает ровно в один канал;
+// оба канала закрываются после закрытия in или отмены контекста ctx.
+//
+// Числа распределяются по одному, поэтому получатель, переставший читать
+// свой канал, задерживает и второй канал. Читайте оба канала в разных
+// горутинах; при отмене ctx Split перестаёт ждать и закрывает каналы,
+// даже если какое-то число так и не было принято.
+func Split(ctx context.Context, in <-chan int64, pred func(int64) bool) (matched, unmatched <-chan int64) {
+	yes := make(chan int64)
+	no := make(chan int64)
+
+	go func() {
+		defer close(yes)
+		defer close(no)
+
+		for {
+			var v int64
+			select {
+			case <-ctx.Done():
+				return
+			case x, ok := <-in:
+				if !ok {
+					return
+				}
+				v = x
+			}
+
+			out := no
+			if pred(v) {
+				out = yes
+			}
+			select {
+			case <-ctx.Done():
+				return
+			case out <- v:
+			}
+		}
+	}()
+
+	return yes, no
+}
+
+// Category — канал чисел одной категории Route.
+type Category struct {
+	Key    string       // категория, которую вернула classify
+	Values <-chan int64 // числа этой категории
+}
+
+// Route раскладывает числа из in по каналам категорий, которые вычисляет
+// classify. Каналы создаются лениво: когда classify впервые возвращает
+// новую категорию, Route создаёт для неё канал и объявляет его в
+// возвращаемом канале categories, а уже потом отправляет в него число.
+// Заранее набор категорий неизвестен, поэтому они приходят через канал:
+// готовую map нельзя было бы безопасно дополнять, пока её читают. После
+// закрытия in или отмены ctx закрываются все каналы категорий, а затем и
+// categories.
+//
+// Как и в Split, числа отправляются по одному: categories и каждый канал
+// категории нужно читать в своей горутине, иначе непрочитанный канал
+// задерживает остальные.
+func Route(ctx context.Context, in <-chan int64, classify func(int64) string) (categories <-chan Category) {
+	announce := make(chan Category)
+
+	go func() {
+		defer close(announce)
+
+		outs := make(map[string]chan int64)
+		defer func() {
+			for _, out := range outs {
+				close(out)
+			}
+		}()
+
+		for {
+			var v int64
+			select {
+			case <-ctx.Done():
+				return
+			case x, ok := <-in:
+				if !ok {
+					return
+				}
+				v = x
+			}
+
+			key := classify(v)
+			out, ok := outs[key]
+			if !ok {
+				out = make(chan int64)
+				outs[key] = out
+				select {
+				case <-ctx.Done():
+					return
+				case announce <- Category{Key: key, Values: out}:
+				}
+			}
+			select {
+			case <-ctx.Done():
+				return
+			case out <- v:
+			}
+		}
+	}()
+
+	return announce
+}
+
+// Transform применяет fn к каждому числу из in и пишет результат в out.
+// Если fn вернула ошибку или запаниковала, исходное число отправляется
+// в dead (очередь недоставленных). После закрытия in закрывается только out: канал dead
+// может быть общим для нескольких стадий. Если fn обёрнута Breaker.Wrap,
+// то пока автомат разомкнут, все числа сразу уходят в dead.
+func Transform(in <-chan int64, out, dead chan<- int64, fn func(int64) (int64, error)) {
+	defer close(out)
+
+	for v := range in {
+		res, err := callSafe(fn, v)
+		if err != nil {
+			dead <- v
+			continue
+		}
+		out <- res
+	}
+}
+
+// ErrOutOfRange — причина отказа Validate: число вне допустимых границ.
+var ErrOutOfRange = errors.New("число вне допустимого диапазона")
+
+// Rejected — число, отклонённое проверкой, вместе с причиной отказа.
+type Rejected struct {
+	Value  int64
+	Reason error
+}
+
+// Validate передаёт из in в out только числа из диапазона [lo, hi], а
+// остальные отправляет в dead с причиной, которая оборачивает
+// ErrOutOfRange. Полезна перед конвейером, если числа приходят извне,
+// например из ReaderSource. После закрытия in закрывается только out:
+// канал dead может быть общим для нескольких стадий; nil dead — отклонённые
+// числа только считаются. Возвращает количество переданных и отклонённых
+// чисел.
+func Validate(in <-chan int64, out chan<- int64, dead chan<- Rejected, lo, hi int64) (inRange, outOfRange int64) {
+	defer close(out)
+
+	for v := range in {
+		if v >= lo && v <= hi {
+			out <- v
+			inRange++
+			continue
+		}
+		outOfRange++
+		if dead != nil {
+			dead <- Rejected{Value: v, Reason: fmt.Errorf("%d не в [%d, %d]: %w", v, lo, hi, ErrOutOfRange)}
+		}
+	}
+	return inRange, outOfRange
+}
+
+// WeightedFanOut распределяет числа из in по каналам outs пропорционально
+// весам weights: канал с весом 3 получает втрое больше чисел, чем канал
+// с весом 1. Используется плавный взвешенный round-robin, поэтому числа
+// одного канала не идут большими пачками. Веса меньше 1 считаются
+// равными 1, len(weights) должно совпадать с len(outs).
+//
+// Распределение жёсткое: если получатель очередного числа занят, ждут
+// все. После закрытия in или отмены ctx все каналы outs закрываются.
+// Если отмена застала уже прочитанное из in число, оно возвращается
+// с lost == true.
+func WeightedFanOut(ctx context.Context, in <-chan int64, outs []chan<- int64, weights []int) (v int64, lost bool) {
+	defer func() {
+		for _, out := range outs {
+			close(out)
+		}
+	}()
+
+	total := 0
+	w := make([]int, len(weights))
+	for i, wi := range weights {
+		w[i] = max(wi, 1)
+		total += w[i]
+	}
+	current := make([]int, len(w))
+
+	for {
+		select {
+		case <-ctx.Done():
+			return 0, false
+		case x, ok := <-in:
+			if !ok {
+				return 0, false
+			}
+			v = x
+		}
+
+		best := 0
+		for i := range current {
+			current[i] += w[i]
+			if current[i] > current[best] {
+				best = i
+			}
+		}
+		current[best] -= total
+
+		select {
+		case <-ctx.Done():
+			return v, true
+		case outs[best] <- v:
+		}
+	}
+}
+
+// HashFanOut направляет каждое число v из in в канал outs[hash(v) % len(outs)],
+// так что одинаковые числа всегда попадают к одному получателю — это
+// позволяет держать состояние по ключу в воркере. Если hash равен nil,
+// используется MixHash. Как и в WeightedFanOut, занятый получатель
+// задерживает всех. После закрытия in или отмены ctx все каналы outs
+// закрываются; уже прочитанное число, которое не удалось передать до
+// отмены, возвращается с lost == true.
+func HashFanOut(ctx context.Context, in <-chan int64, outs []chan<- int64, hash func(int64) uint64) (v int64, lost bool) {
+	defer func() {
+		for _, out := range outs {
+			close(out)
+		}
+	}()
+
+	if hash == nil {
+		hash = MixHash
+	}
+	n := uint64(len(outs))
+
+	for {
+		select {
+		case <-ctx.Done():
+			return 0, false
+		case x, ok := <-in:
+			if !ok {
+				return 0, false
+			}
+			v = x
+		}
+
+		select {
+		case <-ctx.Done():
+			return v, true
+		case outs[hash(v)%n] <- v:
+		}
+	}
+}
+
+// MixHash — хеш по умолчанию для HashFanOut: перемешивание битов из
+// splitmix64. Он не зависит от запуска программы, а соседние числа
+// расходятся по разным каналам.
+func MixHash(v int64) uint64 {
+	x := uint64(v)
+	x ^= x >> 30
+	x *= 0xbf58476d1ce4e5b9
+	x ^= x >> 27
+	x *= 0x94d049bb133111eb
+	x ^= x >> 31
+	return x
+}
+
+// Stage — звено конвейера: читает числа из in и возвращает канал с
+// результатом. Стадия сама запускает свою горутину и закрывает
+// возвращённый канал после закрытия in.
+type Stage func(in <-chan int64) <-chan int64
+
+// Compose последовательно соединяет стадии: выход каждой подаётся на вход
+// следующей, а source — на вход первой. Возвращает выход последней
+// стадии или сам source, если стадий нет. Закрытие source по цепочке
+// закрывает каналы всех стадий.
+func Compose(source <-chan int64, stages ...Stage) <-chan int64 {
+	out := source
+	for _, stage := range stages {
+		out = stage(out)
+	}
+	return out
+}
+
+// FilterStage возвращает Stage, пропускающую только числа, для которых
+// pred возвращает true. См. Filter.
+func FilterStage(pred func(int64) bool) Stage {
+	return func(in <-chan int64) <-chan int64 {
+		out := make(chan int64)
+		go Filter(in, out, pred)
+		return out
+	}
+}
+
+// MapStage возвращает Stage, заменяющую каждое число на fn от него.
+func MapStage(fn func(int64) int64) Stage {
+	return func(in <-chan int64) <-chan int64 {
+		out := make(chan int64)
+		go func() {
+			defer close(out)
+			for v := range in {
+				out <- fn(v)
+			}
+		}()
+		return out
+	}
+}
+
+// CollectSlice читает числа из in, пока он не закроется или не будет
+// отменён контекст ctx, и возвращает их в порядке получения. hint — ожидаемое
+// количество чисел: срез сразу выделяется такой ёмкости, чтобы при
+// точной оценке обойтись без перевыделений. Отрицательный hint считается
+// нулём. При отмене ctx возвращаются уже прочитанные числа.
+func CollectSlice(ctx context.Context, in <-chan int64, hint int) []int64 {
+	values := make([]int64, 0, max(hint, 0))
+	for {
+		select {
+		case <-ctx.Done():
+			return values
+		case v, ok := <-in:
+			if !ok {
+				return values
+			}
+			values = append(values, v)
+		}
+	}
+}
+
+// CollectUnique читает числа из in, пока он не закроется или не будет
+// отменён контекст ctx, и возвращает количество и сумму различных чисел:
+// повторы, которые даёт стадия с повторными попытками (at-least-once),
+// не учитываются, так что сравнение с генератором остаётся осмысленным.
+//
+// Все встреченные числа хранятся в множестве до конца чтения — порядка
+// 40 байт на каждое различное число, поэтому для бесконечных потоков
+// CollectUnique не подходит.
+func CollectUnique(ctx context.Context, in <-chan int64) (count, sum int64) {
+	seen := make(map[int64]struct{})
+	for {
+		select {
+		case <-ctx.Done():
+			return count, sum
+		case v, ok := <-in:
+			if !ok {
+				return count, sum
+			}
+			if _, dup := seen[v]; dup {
+				continue
+			}
+			seen[v] = struct{}{}
+			count++
+			sum += v
+		}
+	}
+}
@@ -0,0 +1,432 @@
+package pipeline
+
+import (
+	"context"
+	"errors"
+	"math/rand"
+	"slices"
+	"sync"
+	"testing"
+	"time"
+)
+
+// readAll читает канал до закрытия и возвращает прочитанные числа.
+func readAll(ch <-chan int64) []int64 {
+	var got []int64
+	for v := range ch {
+		got = append(got, v)
+	}
+	return got
+}
+
+func TestRecordReplay(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	in := make(chan int64)
+	go GenerateUntilSum(ctx, in, 1000, nil)
+	values, out := Record(in)
+	first := readAll(out)
+	cancel()
+
+	recorded := values()
+	if !slices.Equal(recorded, first) {
+		t.Fatalf("записано %v, прошло %v", recorded, first)
+	}
+	replayed := readAll(Replay(context.Background(), recorded))
+	if !slices.Equal(replayed, first) {
+		t.Fatalf("Replay = %v, want %v", replayed, first)
+	}
+}
+
+func TestTee(t *testing.T) {
+	want := []int64{1, 2, 3, 4, 5, 6, 7, 8}
+	a, b := make(chan int64), make(chan int64)
+	go Tee(Replay(context.Background(), want), a, b)
+
+	gotB := make(chan []int64)
+	go func() {
+		gotB <- readAll(b)
+	}()
+	if got := readAll(a); !slices.Equal(got, want) {
+		t.Fatalf("первый выход %v, want %v", got, want)
+	}
+	if got := <-gotB; !slices.Equal(got, want) {
+		t.Fatalf("второй выход %v, want %v", got, want)
+	}
+}
+
+func TestBufferStageSlowConsumer(t *testing.T) {
+	want := make([]int64, 200)
+	for i := range want {
+		want[i] = int64(i + 1)
+	}
+	out := BufferStage(context.Background(), Replay(context.Background(), want), 16)
+
+	var got []int64
+	for v := range out {
+		time.Sleep(50 * time.Microsecond)
+		got = append(got, v)
+	}
+	if !slices.Equal(got, want) {
+		t.Fatalf("получено %d чисел не по порядку или с потерями: %v", len(got), got)
+	}
+}
+
+func TestFilterOdd(t *testing.T) {
+	even := func(v int64) bool { return v%2 == 0 }
+
+	out := make(chan int64)
+	dropped := make(chan int64)
+	go func() {
+		dropped <- Filter(Replay(context.Background(), []int64{1, 2, 3, 4, 5, 6, 7}), out, even)
+	}()
+	if got := readAll(out); !slices.Equal(got, []int64{2, 4, 6}) {
+		t.Fatalf("Filter = %v, want [2 4 6]", got)
+	}
+	if n := <-dropped; n != 4 {
+		t.Fatalf("отброшено %d, want 4", n)
+	}
+
+	s, err := NewPipeline(4, WithFilter(even)).RunFor(context.Background(), 30*time.Millisecond)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if s.Filtered == 0 || s.OutputCount+s.Filtered != s.InputCount {
+		t.Fatalf("OutputCount = %d, Filtered = %d, InputCount = %d", s.OutputCount, s.Filtered, s.InputCount)
+	}
+	if err := CheckInvariants(s); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestSplitPartition(t *testing.T) {
+	in := make([]int64, 100)
+	for i := range in {
+		in[i] = int64(i + 1)
+	}
+	pred := func(v int64) bool { return v%3 == 0 }
+	yes, no := Split(context.Background(), Replay(context.Background(), in), pred)
+
+	gotNo := make(chan []int64)
+	go func() {
+		gotNo <- readAll(no)
+	}()
+	matched := readAll(yes)
+	unmatched := <-gotNo
+
+	for _, v := range matched {
+		if !pred(v) {
+			t.Fatalf("%d попало в matched", v)
+		}
+	}
+	for _, v := range unmatched {
+		if pred(v) {
+			t.Fatalf("%d попало в unmatched", v)
+		}
+	}
+	// каждое число ровно в одном из каналов
+	all := slices.Sorted(slices.Values(slices.Concat(matched, unmatched)))
+	if !slices.Equal(all, in) {
+		t.Fatalf("вместе каналы дают %v, want %v", all, in)
+	}
+}
+
+func TestComposeMapFilter(t *testing.T) {
+	src := Replay(context.Background(), []int64{1, 2, 3, 4, 5, 6})
+	out := Compose(src,
+		MapStage(func(v int64) int64 { return v * 10 }),
+		FilterStage(func(v int64) bool { return v%20 == 0 }),
+	)
+	if got := readAll(out); !slices.Equal(got, []int64{20, 40, 60}) {
+		t.Fatalf("Compose = %v, want [20 40 60]", got)
+	}
+
+	// без стадий Compose возвращает сам source
+	if got := readAll(Compose(Replay(context.Background(), []int64{7}))); !slices.Equal(got, []int64{7}) {
+		t.Fatalf("Compose без стадий = %v, want [7]", got)
+	}
+}
+
+func TestCollectSlice(t *testing.T) {
+	want := make([]int64, 100)
+	for i := range want {
+		want[i] = int64(100 - i)
+	}
+	got := CollectSlice(context.Background(), Replay(context.Background(), want), len(want))
+	if !slices.Equal(got, want) {
+		t.Fatalf("CollectSlice = %v, want %v", got, want)
+	}
+	// при точной подсказке срез не перевыделялся
+	if cap(got) != len(want) {
+		t.Fatalf("cap = %d, want %d", cap(got), len(want))
+	}
+}
+
+func TestCollectUnique(t *testing.T) {
+	// повторы, как после стадии с повторными попытками
+	in := []int64{1, 2, 2, 3, 1, 4, 4, 4, 5}
+	count, sum := CollectUnique(context.Background(), Replay(context.Background(), in))
+	if count != 5 || sum != 15 {
+		t.Fatalf("CollectUnique = %d, %d, want 5, 15", count, sum)
+	}
+}
+
+func TestDedup(t *testing.T) {
+	tests := []struct {
+		window int
+		in     []int64
+		want   []int64
+	}{
+		{0, []int64{1, 2, 1, 3, 2, 2, 4, 1}, []int64{1, 2, 3, 4}},
+		// окно из двух чисел забывает 1, когда приходят 2 и 3
+		{2, []int64{1, 2, 3, 1, 3}, []int64{1, 2, 3, 1}},
+	}
+	for _, tt := range tests {
+		out := make(chan int64)
+		dropped := make(chan int64, 1)
+		go func() {
+			dropped <- Dedup(Replay(context.Background(), tt.in), out, tt.window)
+		}()
+		if got := readAll(out); !slices.Equal(got, tt.want) {
+			t.Fatalf("Dedup(window %d) = %v, want %v", tt.window, got, tt.want)
+		}
+		if n := <-dropped; n != int64(len(tt.in)-len(tt.want)) {
+			t.Fatalf("Dedup(window %d): отброшено %d, want %d", tt.window, n, len(tt.in)-len(tt.want))
+		}
+	}
+}
+
+func TestSampleDeterministic(t *testing.T) {
+	const seed, rate = 42, 0.5
+	in := make([]int64, 1000)
+	for i := range in {
+		in[i] = int64(i)
+	}
+	// ожидаемая выборка — те же решения того же генератора
+	rnd := rand.New(rand.NewSource(seed))
+	var want []int64
+	for _, v := range in {
+		if rnd.Float64() < rate {
+			want = append(want, v)
+		}
+	}
+
+	out := make(chan int64)
+	res := make(chan [2]int64, 1)
+	go func() {
+		seen, forwarded := Sample(Replay(context.Background(), in), out, rate, seed)
+		res <- [2]int64{seen, forwarded}
+	}()
+	got := readAll(out)
+	if !slices.Equal(got, want) {
+		t.Fatalf("Sample отобрал %d чисел, want %d", len(got), len(want))
+	}
+	if r := <-res; r != [2]int64{int64(len(in)), int64(len(want))} {
+		t.Fatalf("Sample = %d, %d, want %d, %d", r[0], r[1], len(in), len(want))
+	}
+	// доля близка к rate
+	if len(want) < 400 || len(want) > 600 {
+		t.Fatalf("отобрано %d из %d при rate %v", len(want), len(in), rate)
+	}
+}
+
+func TestFlushOnCancel(t *testing.T) {
+	stages := map[string]func(ctx context.Context, in <-chan int64) <-chan int64{
+		"BufferStage": func(ctx context.Context, in <-chan int64) <-chan int64 {
+			return BufferStage(ctx, in, 10, FlushOnCancel())
+		},
+		"SpillStage": func(ctx context.Context, in <-chan int64) <-chan int64 {
+			out, _ := SpillStage(ctx, in, 3, t.TempDir(), FlushOnCancel())
+			return out
+		},
+	}
+	for name, start := range stages {
+		t.Run(name, func(t *testing.T) {
+			ctx, cancel := context.WithCancel(context.Background())
+			in := make(chan int64)
+			out := start(ctx, in)
+			// буфер заполнен наполовину, никто не читает
+			want := []int64{1, 2, 3, 4, 5}
+			for _, v := range want {
+				in <- v
+			}
+			cancel()
+			if got := readAll(out); !slices.Equal(got, want) {
+				t.Fatalf("после отмены отдано %v, want %v", got, want)
+			}
+		})
+	}
+}
+
+func TestHashFanOutStable(t *testing.T) {
+	in := []int64{5, 9, 5, 13, 9, 5, 100, 13}
+	outs := make([]chan<- int64, 4)
+	chans := make([]chan int64, len(outs))
+	for i := range outs {
+		chans[i] = make(chan int64, len(in))
+		outs[i] = chans[i]
+	}
+	HashFanOut(context.Background(), Replay(context.Background(), in), outs, nil)
+
+	route := map[int64]int{}
+	total := 0
+	for i, ch := range chans {
+		for v := range ch {
+			total++
+			if j, ok := route[v]; ok && j != i {
+				t.Fatalf("%d попало в каналы %d и %d", v, j, i)
+			}
+			route[v] = i
+			if want := int(MixHash(v) % uint64(len(outs))); i != want {
+				t.Fatalf("%d в канале %d, want %d", v, i, want)
+			}
+		}
+	}
+	if total != len(in) {
+		t.Fatalf("распределено %d чисел, want %d", total, len(in))
+	}
+}
+
+func TestTeeN(t *testing.T) {
+	want := []string{"a", "b", "c", "d"}
+	in := make(chan string)
+	go func() {
+		defer close(in)
+		for _, v := range want {
+			in <- v
+		}
+	}()
+	outs := TeeN(context.Background(), in, 3)
+	if len(outs) != 3 {
+		t.Fatalf("выходов %d, want 3", len(outs))
+	}
+
+	// выходы читаются по очереди: буферы не дают первому задержать остальные
+	for i, out := range outs {
+		var got []string
+		for v := range out {
+			got = append(got, v)
+		}
+		if !slices.Equal(got, want) {
+			t.Fatalf("выход %d = %v, want %v", i, got, want)
+		}
+	}
+}
+
+func TestCompact(t *testing.T) {
+	in := []int64{1, 1, 1, 2, 2, 1, 3, 3, 3, 3, 2}
+	got := readAll(Compact(context.Background(), Replay(context.Background(), in)))
+	if want := []int64{1, 2, 1, 3, 2}; !slices.Equal(got, want) {
+		t.Fatalf("Compact = %v, want %v", got, want)
+	}
+}
+
+func TestValidate(t *testing.T) {
+	values := []int64{-5, 0, 1, 5, 10, 11, 100, 3}
+	const lo, hi = 0, 10
+
+	dead := make(chan Rejected, len(values))
+	out := make(chan int64)
+	type counts struct{ in, out int64 }
+	res := make(chan counts, 1)
+	go func() {
+		in, bad := Validate(Replay(context.Background(), values), out, dead, lo, hi)
+		res <- counts{in, bad}
+	}()
+	got := readAll(out)
+	c := <-res
+	close(dead)
+
+	// границы диапазона включаются
+	if want := []int64{0, 1, 5, 10, 3}; !slices.Equal(got, want) {
+		t.Fatalf("out = %v, want %v", got, want)
+	}
+	var rejected []int64
+	for r := range dead {
+		if !errors.Is(r.Reason, ErrOutOfRange) {
+			t.Fatalf("причина для %d = %v, want ErrOutOfRange", r.Value, r.Reason)
+		}
+		rejected = append(rejected, r.Value)
+	}
+	if want := []int64{-5, 11, 100}; !slices.Equal(rejected, want) {
+		t.Fatalf("dead = %v, want %v", rejected, want)
+	}
+	if c.in != 5 || c.out != 3 {
+		t.Fatalf("Validate = %d, %d, want 5, 3", c.in, c.out)
+	}
+
+	// без dead отклонённые числа только считаются
+	out = make(chan int64)
+	go func() {
+		in, bad := Validate(Replay(context.Background(), values), out, nil, lo, hi)
+		res <- counts{in, bad}
+	}()
+	if got := readAll(out); len(got) != 5 {
+		t.Fatalf("out без dead = %v", got)
+	}
+	if c := <-res; c.in != 5 || c.out != 3 {
+		t.Fatalf("Validate без dead = %d, %d, want 5, 3", c.in, c.out)
+	}
+}
+
+func TestRouteEvenOdd(t *testing.T) {
+	values := []int64{1, 2, 3, 4, 5, 6, 7}
+	parity := func(v int64) string {
+		if v%2 == 0 {
+			return "even"
+		}
+		return "odd"
+	}
+	categories := Route(context.Background(), Replay(context.Background(), values), parity)
+
+	// каждый канал категории читается в своей горутине
+	var mu sync.Mutex
+	var wg sync.WaitGroup
+	got := make(map[string][]int64)
+	for c := range categories {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			vals := readAll(c.Values)
+			mu.Lock()
+			got[c.Key] = vals
+			mu.Unlock()
+		}()
+	}
+	wg.Wait()
+
+	want := map[string][]int64{"odd": {1, 3, 5, 7}, "even": {2, 4, 6}}
+	if len(got) != len(want) {
+		t.Fatalf("категории %v, want %v", got, want)
+	}
+	for key, vals := range want {
+		if !slices.Equal(got[key], vals) {
+			t.Fatalf("%s = %v, want %v", key, got[key], vals)
+		}
+	}
+}
+
+func TestBatch(t *testing.T) {
+	// без отправки по времени пачки набираются по размеру, остаток — при закрытии
+	var got [][]int64
+	for b := range Batch(context.Background(), Replay(context.Background(), []int64{1, 2, 3, 4, 5, 6, 7}), 3, 0) {
+		got = append(got, b)
+	}
+	want := [][]int64{{1, 2, 3}, {4, 5, 6}, {7}}
+	if !slices.EqualFunc(got, want, slices.Equal) {
+		t.Fatalf("пачки %v, want %v", got, want)
+	}
+
+	// при отмене с FlushOnCancel остаток не теряется
+	ctx, cancel := context.WithCancel(context.Background())
+	in := make(chan int64)
+	batches := Batch(ctx, in, 10, 0, FlushOnCancel())
+	in <- 1
+	in <- 2
+	cancel()
+	if b := <-batches; !slices.Equal(b, []int64{1, 2}) {
+		t.Fatalf("остаток после отмены %v, want [1 2]", b)
+	}
+	if _, ok := <-batches; ok {
+		t.Fatal("канал пачек не закрыт после отмены")
+	}
+}
@@ -0,0 +1,269 @@
+package pipeline
+
+import (
+	"encoding/json"
+	"errors"
+	"math/big"
+	"slices"
+	"time"
+)
+
+// Merge добавляет к s статистику другого запуска other, чтобы получить
+// итог нескольких конвейеров или нескольких запусков после Reset.
+//
+// Количества и суммы складываются; если хотя бы один запуск шёл с
+// WithSaturatingSums, суммы входа и выхода складываются с насыщением.
+// PerChannel складываются поэлементно: i-й элемент — сколько чисел
+// прошло через i-й канал во всех запусках, а если количество воркеров
+// различалось, короткий слайс дополняется нулями. Точные суммы
+// (BigInputSum, BigOutputSum) остаются, только если были в обоих
+// запусках; пустая статистика без чисел, например нулевое значение Stats,
+// с которого начинают суммирование, считается нулём. Из Latency точно
+// объединяются Min и Max, а P50 и P95 берутся наибольшие из двух — это
+// оценка сверху для объединения. ScaleEvents, Workers и MissingSeqs
+// дописываются друг за другом: номера в MissingSeqs относятся каждый к
+// своему запуску. RunID, Err, StopReason и AutoWorkers берутся из s, если
+// там они не пустые, иначе из other; так же и SampleRate: суммы с
+// выборкой остаются выборочными. Слайсы s на месте не меняются, так что
+// копии s, которые делят с ним память, Merge не затрагивает.
+func (s *Stats) Merge(other Stats) {
+	if s.RunID == "" {
+		s.RunID = other.RunID
+	}
+	if s.Err == nil {
+		s.Err = other.Err
+	}
+	if s.StopReason == nil {
+		s.StopReason = other.StopReason
+	}
+
+	// big-суммы считаются до сложения счётчиков, пока пустоту s ещё видно
+	bigIn, bigOut, exact := mergeBig(s, &other)
+
+	s.InputCount += other.InputCount
+	s.OutputCount += other.OutputCount
+	s.Saturating = s.Saturating || other.Saturating
+	if s.Saturating {
+		s.InputSum = satAdd(s.InputSum, other.InputSum)
+		s.OutputSum = satAdd(s.OutputSum, other.OutputSum)
+	} else {
+		s.InputSum += other.InputSum
+		s.OutputSum += other.OutputSum
+	}
+	s.Transformed = s.Transformed || other.Transformed
+	if s.SampleRate == 0 {
+		s.SampleRate = other.SampleRate
+	}
+	if s.AutoWorkers == 0 {
+		s.AutoWorkers = other.AutoWorkers
+	}
+	s.Filtered += other.Filtered
+	s.FilteredSum += other.FilteredSum
+	s.Dropped += other.Dropped
+	s.DroppedSum += other.DroppedSum
+	s.PanicCount += other.PanicCount
+	s.Invalid += other.Invalid
+	s.DeadLettered += other.DeadLettered
+	s.DeadLetteredSum += other.DeadLetteredSum
+
+	// слайсы s могут делить память с копиями s, поэтому складываются в новые
+	perChannel := slices.Clone(s.PerChannel)
+	if len(other.PerChannel) > len(perChannel) {
+		perChannel = append(perChannel, make([]int64, len(other.PerChannel)-len(perChannel))...)
+	}
+	for i, v := range other.PerChannel {
+		perChannel[i] += v
+	}
+	s.PerChannel = perChannel
+
+	if exact {
+		s.BigInputSum, s.BigOutputSum = bigIn, bigOut
+	} else {
+		s.BigInputSum, s.BigOutputSum = nil, nil
+	}
+
+	s.Latency = mergeLatency(s.Latency, other.Latency)
+	s.MissingSeqs = slices.Concat(s.MissingSeqs, other.MissingSeqs)
+	s.ScaleEvents = slices.Concat(s.ScaleEvents, other.ScaleEvents)
+	s.Workers = slices.Concat(s.Workers, other.Workers)
+}
+
+// mergeLatency объединяет сводки задержки двух запусков, см. Stats.Merge.
+// Пустая сводка (запуск без WithLatency или без чисел) не учитывается.
+func mergeLatency(a, b LatencyStats) LatencyStats {
+	switch {
+	case b == LatencyStats{}:
+		return a
+	case a == LatencyStats{}:
+		return b
+	}
+	return LatencyStats{
+		Min: min(a.Min, b.Min),
+		Max: max(a.Max, b.Max),
+		P50: max(a.P50, b.P50),
+		P95: max(a.P95, b.P95),
+	}
+}
+
+// mergeBig складывает точные суммы a и b. exact ложно, если точных сумм
+// нет ни у одной стороны или нет у стороны с числами.
+func mergeBig(a, b *Stats) (in, out *big.Int, exact bool) {
+	in, out = new(big.Int), new(big.Int)
+	for _, s := range []*Stats{a, b} {
+		if s.BigInputSum == nil || s.BigOutputSum == nil {
+			if s.InputCount != 0 || s.OutputCount != 0 {
+				return nil, nil, false
+			}
+			continue
+		}
+		in.Add(in, s.BigInputSum)
+		out.Add(out, s.BigOutputSum)
+		exact = true
+	}
+	return in, out, exact
+}
+
+// statsJSON — представление Stats в JSON. Имена полей — часть формата и
+// не меняются: новые поля только добавляются. Длительности записываются
+// в наносекундах, ошибки — текстом, точные суммы — числами JSON.
+type statsJSON struct {
+	RunID           string            `json:"run_id,omitempty"`
+	Err             string            `json:"err,omitempty"`
+	StopReason      string            `json:"stop_reason,omitempty"`
+	InputCount      int64             `json:"input_count"`
+	InputSum        int64             `json:"input_sum"`
+	OutputCount     int64             `json:"output_count"`
+	OutputSum       int64             `json:"output_sum"`
+	PerChannel      []int64           `json:"per_channel"`
+	Transformed     bool              `json:"transformed,omitempty"`
+	Filtered        int64             `json:"filtered"`
+	FilteredSum     int64             `json:"filtered_sum"`
+	Dropped         int64             `json:"dropped"`
+	DroppedSum      int64             `json:"dropped_sum"`
+	PanicCount      int64             `json:"panic_count"`
+	Invalid         int64             `json:"invalid"`
+	DeadLettered    int64             `json:"dead_lettered"`
+	DeadLetteredSum int64             `json:"dead_lettered_sum"`
+	BigInputSum     *big.Int          `json:"big_input_sum,omitempty"`
+	BigOutputSum    *big.Int          `json:"big_output_sum,omitempty"`
+	Saturating      bool              `json:"saturating,omitempty"`
+	SampleRate      float64           `json:"sample_rate,omitempty"`
+	Latency         latencyJSON       `json:"latency"`
+	MissingSeqs     []int64           `json:"missing_seqs,omitempty"`
+	ScaleEvents     []scaleEventJSON  `json:"scale_events,omitempty"`
+	AutoWorkers     int               `json:"auto_workers,omitempty"`
+	Workers         []*workerStatJSON `json:"workers,omitempty"`
+}
+
+// latencyJSON — LatencyStats в statsJSON.
+type latencyJSON struct {
+	Min time.Duration `json:"min_ns"`
+	Max time.Duration `json:"max_ns"`
+	P50 time.Duration `json:"p50_ns"`
+	P95 time.Duration `json:"p95_ns"`
+}
+
+// scaleEventJSON — ScaleEvent в statsJSON.
+type scaleEventJSON struct {
+	At      time.Duration `json:"at_ns"`
+	Workers int           `json:"workers"`
+}
+
+// workerStatJSON — WorkerStat в statsJSON.
+type workerStatJSON struct {
+	Processed int64         `json:"processed"`
+	Busy      time.Duration `json:"busy_ns"`
+	Last      int64         `json:"last"`
+	HasLast   bool          `json:"has_last"`
+}
+
+// MarshalJSON записывает s в JSON с постоянными именами полей в стиле
+// snake_case: input_count, output_sum, per_channel, latency.p95_ns и т.д.
+// Err и StopReason записываются текстом ошибки, пустые необязательные
+// сводки опускаются.
+func (s Stats) MarshalJSON() ([]byte, error) {
+	j := statsJSON{
+		RunID:           s.RunID,
+		InputCount:      s.InputCount,
+		InputSum:        s.InputSum,
+		OutputCount:     s.OutputCount,
+		OutputSum:       s.OutputSum,
+		PerChannel:      s.PerChannel,
+		Transformed:     s.Transformed,
+		Filtered:        s.Filtered,
+		FilteredSum:     s.FilteredSum,
+		Dropped:         s.Dropped,
+		DroppedSum:      s.DroppedSum,
+		PanicCount:      s.PanicCount,
+		Invalid:         s.Invalid,
+		DeadLettered:    s.DeadLettered,
+		DeadLetteredSum: s.DeadLetteredSum,
+		BigInputSum:     s.BigInputSum,
+		BigOutputSum:    s.BigOutputSum,
+		Saturating:      s.Saturating,
+		SampleRate:      s.SampleRate,
+		Latency:         latencyJSON(s.Latency),
+		MissingSeqs:     s.MissingSeqs,
+		AutoWorkers:     s.AutoWorkers,
+	}
+	if s.Err != nil {
+		j.Err = s.Err.Error()
+	}
+	if s.StopReason != nil {
+		j.StopReason = s.StopReason.Error()
+	}
+	for _, e := range s.ScaleEvents {
+		j.ScaleEvents = append(j.ScaleEvents, scaleEventJSON(e))
+	}
+	for _, w := range s.Workers {
+		j.Workers = append(j.Workers, (*workerStatJSON)(w))
+	}
+	return json.Marshal(j)
+}
+
+// UnmarshalJSON читает s из формата MarshalJSON. Ошибки восстанавливаются
+// только текстом: errors.Is с ErrRunTimeout и другими причинами для них
+// не срабатывает.
+func (s *Stats) UnmarshalJSON(data []byte) error {
+	var j statsJSON
+	if err := json.Unmarshal(data, &j); err != nil {
+		return err
+	}
+	*s = Stats{
+		RunID:           j.RunID,
+		InputCount:      j.InputCount,
+		InputSum:        j.InputSum,
+		OutputCount:     j.OutputCount,
+		OutputSum:       j.OutputSum,
+		PerChannel:      j.PerChannel,
+		Transformed:     j.Transformed,
+		Filtered:        j.Filtered,
+		FilteredSum:     j.FilteredSum,
+		Dropped:         j.Dropped,
+		DroppedSum:      j.DroppedSum,
+		PanicCount:      j.PanicCount,
+		Invalid:         j.Invalid,
+		DeadLettered:    j.DeadLettered,
+		DeadLetteredSum: j.DeadLetteredSum,
+		BigInputSum:     j.BigInputSum,
+		BigOutputSum:    j.BigOutputSum,
+		Saturating:      j.Saturating,
+		SampleRate:      j.SampleRate,
+		Latency:         LatencyStats(j.Latency),
+		MissingSeqs:     j.MissingSeqs,
+		AutoWorkers:     j.AutoWorkers,
+	}
+	if j.Err != "" {
+		s.Err = errors.New(j.Err)
+	}
+	if j.StopReason != "" {
+		s.StopReason = errors.New(j.StopReason)
+	}
+	for _, e := range j.ScaleEvents {
+		s.ScaleEvents = append(s.ScaleEvents, ScaleEvent(e))
+	}
+	for _, w := range j.Workers {
+		s.Workers = append(s.Workers, (*WorkerStat)(w))
+	}
+	return nil
+}
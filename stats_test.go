@@ -0,0 +1,148 @@
+package pipeline
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"math/big"
+	"reflect"
+	"slices"
+	"testing"
+	"time"
+)
+
+func TestStatsMerge(t *testing.T) {
+	a, err := NewPipeline(2, WithBigSums()).RunFor(context.Background(), 20*time.Millisecond)
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, err := NewPipeline(3, WithBigSums()).RunFor(context.Background(), 20*time.Millisecond)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var total Stats
+	total.Merge(a)
+	total.Merge(b)
+	if total.InputCount != a.InputCount+b.InputCount || total.InputSum != a.InputSum+b.InputSum {
+		t.Fatalf("вход %d/%d, want %d/%d", total.InputCount, total.InputSum, a.InputCount+b.InputCount, a.InputSum+b.InputSum)
+	}
+	if total.OutputCount != a.OutputCount+b.OutputCount || total.OutputSum != a.OutputSum+b.OutputSum {
+		t.Fatalf("выход %d/%d, want %d/%d", total.OutputCount, total.OutputSum, a.OutputCount+b.OutputCount, a.OutputSum+b.OutputSum)
+	}
+	want := []int64{a.PerChannel[0] + b.PerChannel[0], a.PerChannel[1] + b.PerChannel[1], b.PerChannel[2]}
+	if !slices.Equal(total.PerChannel, want) {
+		t.Fatalf("PerChannel = %v, want %v", total.PerChannel, want)
+	}
+	if total.BigInputSum == nil || total.BigInputSum.Int64() != total.InputSum {
+		t.Fatalf("BigInputSum = %v, want %d", total.BigInputSum, total.InputSum)
+	}
+	if err := CheckInvariants(total); err != nil {
+		t.Fatal(err)
+	}
+
+	// запуск без точных сумм делает итог неточным
+	c, err := NewPipeline(3).RunFor(context.Background(), 20*time.Millisecond)
+	if err != nil {
+		t.Fatal(err)
+	}
+	total.Merge(c)
+	if total.BigInputSum != nil {
+		t.Fatalf("BigInputSum = %v, want nil", total.BigInputSum)
+	}
+	if err := CheckInvariants(total); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestStatsMergeDoesNotAlias(t *testing.T) {
+	a := Stats{
+		PerChannel:  []int64{1, 2},
+		MissingSeqs: make([]int64, 1, 4),
+		Workers:     make([]*WorkerStat, 1, 4),
+	}
+	b := a
+	b.Merge(a)
+
+	if !slices.Equal(a.PerChannel, []int64{1, 2}) {
+		t.Fatalf("Merge изменил PerChannel копии: %v", a.PerChannel)
+	}
+	if !slices.Equal(b.PerChannel, []int64{2, 4}) {
+		t.Fatalf("PerChannel = %v, want [2 4]", b.PerChannel)
+	}
+
+	// дописывание в общий запас ёмкости не должно быть видно через a
+	b.Merge(Stats{MissingSeqs: []int64{7}, Workers: []*WorkerStat{{Processed: 7}}})
+	if got := a.MissingSeqs[:2]; got[1] != 0 {
+		t.Fatalf("Merge дописал MissingSeqs в память копии: %v", got)
+	}
+	if got := a.Workers[:2]; got[1] != nil {
+		t.Fatalf("Merge дописал Workers в память копии: %v", got)
+	}
+}
+
+func TestStatsJSONRoundTrip(t *testing.T) {
+	in := Stats{
+		RunID:           "run-7",
+		Err:             errors.New("сбой"),
+		StopReason:      ErrRunTimeout,
+		InputCount:      10,
+		InputSum:        55,
+		OutputCount:     7,
+		OutputSum:       40,
+		PerChannel:      []int64{3, 4},
+		Transformed:     true,
+		Filtered:        1,
+		FilteredSum:     2,
+		Dropped:         1,
+		DroppedSum:      9,
+		PanicCount:      1,
+		Invalid:         2,
+		DeadLettered:    1,
+		DeadLetteredSum: 4,
+		BigInputSum:     big.NewInt(55),
+		BigOutputSum:    big.NewInt(40),
+		Saturating:      true,
+		SampleRate:      0.5,
+		Latency:         LatencyStats{Min: time.Millisecond, Max: 9 * time.Millisecond, P50: 2 * time.Millisecond, P95: 8 * time.Millisecond},
+		MissingSeqs:     []int64{6},
+		ScaleEvents:     []ScaleEvent{{At: time.Second, Workers: 2}},
+		AutoWorkers:     2,
+		Workers:         []*WorkerStat{{Processed: 3, Busy: time.Millisecond, Last: 9, HasLast: true}, {Processed: 4}},
+	}
+	data, err := json.Marshal(in)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// имена полей — часть формата
+	var raw map[string]any
+	if err := json.Unmarshal(data, &raw); err != nil {
+		t.Fatal(err)
+	}
+	for _, key := range []string{"run_id", "err", "stop_reason", "input_count", "output_sum", "per_channel", "latency", "workers"} {
+		if _, ok := raw[key]; !ok {
+			t.Fatalf("в %s нет поля %q", data, key)
+		}
+	}
+	if p95 := raw["latency"].(map[string]any)["p95_ns"]; p95 != float64(8*time.Millisecond) {
+		t.Fatalf("latency.p95_ns = %v, want %d", p95, 8*time.Millisecond)
+	}
+
+	var out Stats
+	if err := json.Unmarshal(data, &out); err != nil {
+		t.Fatal(err)
+	}
+	// ошибки восстанавливаются только текстом
+	if out.Err.Error() != in.Err.Error() || out.StopReason.Error() != in.StopReason.Error() {
+		t.Fatalf("Err = %v, StopReason = %v, want %v, %v", out.Err, out.StopReason, in.Err, in.StopReason)
+	}
+	if out.BigInputSum.Cmp(in.BigInputSum) != 0 || out.BigOutputSum.Cmp(in.BigOutputSum) != 0 {
+		t.Fatalf("точные суммы = %v, %v, want %v, %v", out.BigInputSum, out.BigOutputSum, in.BigInputSum, in.BigOutputSum)
+	}
+	in.Err, in.StopReason, in.BigInputSum, in.BigOutputSum = nil, nil, nil, nil
+	out.Err, out.StopReason, out.BigInputSum, out.BigOutputSum = nil, nil, nil, nil
+	if !reflect.DeepEqual(out, in) {
+		t.Fatalf("после JSON %+v, want %+v", out, in)
+	}
+}
@@ -0,0 +1,125 @@
+package pipeline
+
+import (
+	"context"
+	"math/rand"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// stressRand возвращает генератор случайных чисел для стресс-теста и
+// пишет его seed в лог, чтобы упавший запуск можно было повторить.
+func stressRand(t *testing.T) *rand.Rand {
+	seed := time.Now().UnixNano()
+	t.Logf("seed %d", seed)
+	return rand.New(rand.NewSource(seed))
+}
+
+func TestStressPipeline(t *testing.T) {
+	rnd := stressRand(t)
+	for i := range 8 {
+		workers := 1 + rnd.Intn(64)
+		d := time.Duration(5+rnd.Intn(35)) * time.Millisecond
+		var opts []Option
+		if rnd.Intn(2) == 0 {
+			opts = append(opts, WithInputBuffer(rnd.Intn(32)))
+		}
+		if rnd.Intn(2) == 0 {
+			opts = append(opts, WithConsumers(1+rnd.Intn(4)))
+		}
+		if rnd.Intn(2) == 0 {
+			opts = append(opts, WithWorkerOptions(WithJitter(0, time.Duration(rnd.Intn(1000))*time.Microsecond)))
+		}
+
+		s, err := NewPipeline(workers, opts...).RunFor(context.Background(), d)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := CheckInvariants(s); err != nil {
+			t.Fatalf("запуск %d (%d воркеров, %v): %v", i, workers, d, err)
+		}
+		if len(s.PerChannel) != workers {
+			t.Fatalf("запуск %d: PerChannel из %d элементов, want %d", i, len(s.PerChannel), workers)
+		}
+	}
+}
+
+func TestStressPrimitives(t *testing.T) {
+	rnd := stressRand(t)
+	for i := range 8 {
+		workers := 1 + rnd.Intn(64)
+		d := time.Duration(5+rnd.Intn(35)) * time.Millisecond
+		ctx, cancel := context.WithTimeout(context.Background(), d)
+
+		// генератор → fanout(workers) → fanin → сбор
+		var inputCount, inputSum atomic.Int64
+		in := make(chan int64)
+		genDone := make(chan struct{})
+		go func() {
+			defer close(genDone)
+			Generator(ctx, in, func(v int64) {
+				inputCount.Add(1)
+				inputSum.Add(v)
+			})
+		}()
+		outs := make([]<-chan int64, workers)
+		for w := range outs {
+			out := make(chan int64)
+			outs[w] = out
+			go Worker(ctx, in, out, WithJitter(0, 0))
+		}
+		var count, sum int64
+		for v := range FanIn(context.Background(), outs...) {
+			count++
+			sum += v
+		}
+		cancel()
+		// fn вызывается после отправки, поэтому счётчики входа читаются
+		// только после остановки генератора
+		<-genDone
+
+		if count != inputCount.Load() || sum != inputSum.Load() {
+			t.Fatalf("запуск %d (%d воркеров, %v): вход %d/%d, выход %d/%d",
+				i, workers, d, inputCount.Load(), inputSum.Load(), count, sum)
+		}
+	}
+}
+
+// TestStressCancel многократно отменяет запуск в случайный момент: под
+// -race он ловит отправку в закрытый канал и гонки при остановке.
+func TestStressCancel(t *testing.T) {
+	rnd := stressRand(t)
+	for i := range 200 {
+		var opts []Option
+		switch i % 5 {
+		case 1:
+			opts = append(opts, WithFilter(func(v int64) bool { return v%2 == 0 }))
+		case 2:
+			opts = append(opts, WithAutoscale(Autoscale{Min: 1, Max: 4, ScaleUp: 0.5, ScaleDown: 0.1, Interval: time.Millisecond}))
+		case 3:
+			opts = append(opts, WithWeights(1, 2, 3), WithPanicRecovery())
+		case 4:
+			opts = append(opts, WithConsumers(3), WithInputBuffer(16), WithPoolProcess(func(v int64) int64 { return 2 * v }))
+		}
+		p := NewPipeline(1+i%7, opts...)
+
+		// иногда вместе с генератором сразу останавливаются и воркеры
+		genCtx, genCancel := context.WithCancel(context.Background())
+		workCtx, workCancel := context.WithCancel(context.Background())
+		after := time.Duration(rnd.Intn(2000)) * time.Microsecond
+		both := rnd.Intn(2) == 0
+		go func() {
+			time.Sleep(after)
+			if both {
+				workCancel()
+			}
+			genCancel()
+		}()
+		s := p.RunStages(genCtx, workCtx)
+		workCancel()
+		if err := CheckInvariants(s); err != nil {
+			t.Fatalf("запуск %d (отмена через %v): %v", i, after, err)
+		}
+	}
+}
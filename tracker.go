@@ -0,0 +1,295 @@
+package pipeline
+
+import (
+	"sort"
+	"sync"
+	"time"
+)
+
+// LatencyStats — время, которое числа провели в конвейере от генерации
+// до результирующего канала.
+type LatencyStats struct {
+	Min time.Duration
+	Max time.Duration
+	P50 time.Duration
+	P95 time.Duration
+}
+
+// flight — сведения о числе, находящемся в пути.
+type flight struct {
+	seq  int64     // порядковый номер числа, начиная с 1
+	at   time.Time // время генерации
+	cold bool      // число взято воркером во время разогрева, см. WithWarmup
+}
+
+// flightTracker следит за числами от генерации до результирующего
+// канала: присваивает каждому порядковый номер, запоминает время
+// генерации и при поступлении записывает задержку.
+//
+// Чтобы каналы конвейера остались каналами int64, сведения о числе
+// хранятся не в обёртке вокруг него, а в таблице по значению, см.
+// flightTable. Запись удаляется, как только число дошло до конца или было
+// законно отброшено, так что таблица занимает память только под числа в
+// пути — и те, что потерялись: их номера и есть пропуски. Для
+// перцентилей хранятся все задержки запуска — по 8 байт на число.
+//
+// Если воркеры меняют числа, обработанные числа переносятся в отдельную
+// таблицу по новому значению, чтобы не спутать их с ещё не обработанными.
+//
+// Методы безопасны для nil-указателя и тогда ничего не делают.
+type flightTracker struct {
+	mu        sync.Mutex
+	seq       int64
+	inFlight  *flightTable // ещё не обработанные числа
+	processed *flightTable // обработанные числа по новому значению, если числа меняются
+	samples   []time.Duration
+}
+
+// newFlightTracker создаёт трекер. Если transformed истинно, воркеры
+// меняют числа и сообщают об этом через rekey.
+func newFlightTracker(transformed bool) *flightTracker {
+	t := &flightTracker{inFlight: newFlightTable()}
+	if transformed {
+		t.processed = newFlightTable()
+	}
+	return t
+}
+
+// done возвращает таблицу, в которой ищутся числа на выходе воркеров.
+// Вызывается под t.mu.
+func (t *flightTracker) done() *flightTable {
+	if t.processed != nil {
+		return t.processed
+	}
+	return t.inFlight
+}
+
+// emit отмечает генерацию числа v.
+func (t *flightTracker) emit(v int64) {
+	if t == nil {
+		return
+	}
+	now := time.Now()
+	t.mu.Lock()
+	t.seq++
+	t.inFlight.put(v, flight{seq: t.seq, at: now})
+	t.mu.Unlock()
+}
+
+// unemit отменяет emit последнего числа v, которое так и не было отправлено.
+func (t *flightTracker) unemit(v int64) {
+	if t == nil {
+		return
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	t.inFlight.takeLast(v)
+	t.seq--
+}
+
+// rekey отмечает, что воркер превратил число v в to, и дальше его нужно
+// искать по новому значению.
+func (t *flightTracker) rekey(v, to int64) {
+	if t == nil {
+		return
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if f, ok := t.inFlight.take(v); ok {
+		t.processed.put(to, f)
+	}
+}
+
+// warmUp отмечает, что число v взято воркером во время разогрева: оно
+// дойдёт до конца как обычно, но его задержка не попадёт в сводку.
+func (t *flightTracker) warmUp(v int64) {
+	if t == nil {
+		return
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	t.inFlight.warmUp(v)
+}
+
+// dropInput забывает число v, которое не дошло до воркера.
+func (t *flightTracker) dropInput(v int64) {
+	if t == nil {
+		return
+	}
+	t.mu.Lock()
+	t.inFlight.take(v)
+	t.mu.Unlock()
+}
+
+// drop забывает число v с выхода воркера, которое законно не дойдёт до
+// результирующего канала, например отброшено фильтром.
+func (t *flightTracker) drop(v int64) {
+	if t == nil {
+		return
+	}
+	t.mu.Lock()
+	t.done().take(v)
+	t.mu.Unlock()
+}
+
+// arrive записывает задержку числа v, дошедшего до результирующего канала.
+func (t *flightTracker) arrive(v int64) {
+	if t == nil {
+		return
+	}
+	now := time.Now()
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if f, ok := t.done().take(v); ok && !f.cold {
+		t.samples = append(t.samples, now.Sub(f.at))
+	}
+}
+
+// latency считает итоговую задержку. Вызывается после завершения запуска.
+func (t *flightTracker) latency() LatencyStats {
+	if t == nil {
+		return LatencyStats{}
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if len(t.samples) == 0 {
+		return LatencyStats{}
+	}
+	sort.Slice(t.samples, func(i, j int) bool { return t.samples[i] < t.samples[j] })
+	return LatencyStats{
+		Min: t.samples[0],
+		Max: t.samples[len(t.samples)-1],
+		P50: percentile(t.samples, 50),
+		P95: percentile(t.samples, 95),
+	}
+}
+
+// missing возвращает по возрастанию порядковые номера чисел, которые
+// были сгенерированы, но не дошли до результирующего канала и не были
+// отброшены. Вызывается после завершения запуска.
+func (t *flightTracker) missing() []int64 {
+	if t == nil {
+		return nil
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	var seqs []int64
+	collect := func(f flight) { seqs = append(seqs, f.seq) }
+	t.inFlight.each(collect)
+	t.processed.each(collect)
+	sort.Slice(seqs, func(i, j int) bool { return seqs[i] < seqs[j] })
+	return seqs
+}
+
+// flightTable — таблица чисел в пути по значению. Одно и то же значение
+// может быть в пути несколько раз (WithSource, RandomSource), поэтому
+// копии хранятся по очереди: take забирает самую раннюю, а takeLast —
+// самую позднюю. Какая из копий на самом деле дошла до конца, по значению
+// не различить, поэтому задержки повторов могут перепутаться между собой,
+// но ни одна копия не теряется и не стирает другую. Первая копия лежит
+// прямо в first, так что неповторяющиеся числа не требуют лишней памяти.
+// Методы безопасны для nil-указателя: таблица тогда пуста.
+type flightTable struct {
+	first map[int64]flight   // самая ранняя копия каждого значения
+	more  map[int64][]flight // остальные копии по порядку, если они есть
+}
+
+func newFlightTable() *flightTable {
+	return &flightTable{first: make(map[int64]flight), more: make(map[int64][]flight)}
+}
+
+// put добавляет копию значения v.
+func (t *flightTable) put(v int64, f flight) {
+	if _, ok := t.first[v]; ok {
+		t.more[v] = append(t.more[v], f)
+		return
+	}
+	t.first[v] = f
+}
+
+// take забирает самую раннюю копию значения v.
+func (t *flightTable) take(v int64) (flight, bool) {
+	if t == nil {
+		return flight{}, false
+	}
+	f, ok := t.first[v]
+	if !ok {
+		return flight{}, false
+	}
+	if rest := t.more[v]; len(rest) > 0 {
+		t.first[v] = rest[0]
+		t.setMore(v, rest[1:])
+	} else {
+		delete(t.first, v)
+	}
+	return f, true
+}
+
+// takeLast забирает самую позднюю копию значения v.
+func (t *flightTable) takeLast(v int64) {
+	if rest := t.more[v]; len(rest) > 0 {
+		t.setMore(v, rest[:len(rest)-1])
+		return
+	}
+	delete(t.first, v)
+}
+
+// setMore сохраняет оставшиеся копии значения v.
+func (t *flightTable) setMore(v int64, rest []flight) {
+	if len(rest) == 0 {
+		delete(t.more, v)
+		return
+	}
+	t.more[v] = rest
+}
+
+// warmUp помечает холодной самую раннюю копию значения v, которая ещё не
+// помечена, см. flightTracker.warmUp.
+func (t *flightTable) warmUp(v int64) {
+	f, ok := t.first[v]
+	if !ok {
+		return
+	}
+	if !f.cold {
+		f.cold = true
+		t.first[v] = f
+		return
+	}
+	for i := range t.more[v] {
+		if !t.more[v][i].cold {
+			t.more[v][i].cold = true
+			return
+		}
+	}
+}
+
+// each вызывает fn для каждой копии каждого значения.
+func (t *flightTable) each(fn func(flight)) {
+	if t == nil {
+		return
+	}
+	for _, f := range t.first {
+		fn(f)
+	}
+	for _, rest := range t.more {
+		for _, f := range rest {
+			fn(f)
+		}
+	}
+}
+
+// percentile возвращает p-й перцентиль отсортированного слайса
+// по методу ближайшего ранга.
+func percentile(sorted []time.Duration, p int) time.Duration {
+	i := (len(sorted)*p + 99) / 100
+	if i > 0 {
+		i--
+	}
+	return sorted[i]
+}
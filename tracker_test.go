@@ -0,0 +1,160 @@
+package pipeline
+
+import (
+	"context"
+	"errors"
+	"slices"
+	"testing"
+	"time"
+)
+
+func TestLatencyAtLeastProcessing(t *testing.T) {
+	const delay = 2 * time.Millisecond
+	p := NewPipeline(4, WithLatency(), WithPoolProcess(func(v int64) int64 {
+		time.Sleep(delay)
+		return v
+	}))
+
+	s, err := p.RunFor(context.Background(), 50*time.Millisecond)
+	if err != nil {
+		t.Fatal(err)
+	}
+	l := s.Latency
+	if l.Min < delay {
+		t.Fatalf("Latency.Min = %v, want не меньше %v", l.Min, delay)
+	}
+	if l.Min > l.P50 || l.P50 > l.P95 || l.P95 > l.Max {
+		t.Fatalf("Latency не упорядочена: %+v", l)
+	}
+}
+
+func TestTrackerDuplicates(t *testing.T) {
+	tr := newFlightTracker(false)
+	tr.emit(5)
+	tr.emit(5)
+	tr.emit(7)
+	tr.emit(5)
+	// последняя пятёрка так и не была отправлена
+	tr.unemit(5)
+
+	tr.arrive(5)
+	if got := tr.missing(); !slices.Equal(got, []int64{2, 3}) {
+		t.Fatalf("missing = %v, want [2 3]", got)
+	}
+	tr.arrive(5)
+	tr.arrive(7)
+	if got := tr.missing(); got != nil {
+		t.Fatalf("missing = %v, want пусто", got)
+	}
+	if len(tr.samples) != 3 {
+		t.Fatalf("задержек %d, want 3", len(tr.samples))
+	}
+}
+
+func TestTrackerDuplicatesRekey(t *testing.T) {
+	tr := newFlightTracker(true)
+	tr.emit(1)
+	tr.emit(1)
+	tr.emit(2)
+	// воркеры сводят разные числа к одному результату
+	tr.rekey(1, 10)
+	tr.rekey(2, 10)
+	tr.rekey(1, 10)
+
+	tr.arrive(10)
+	tr.drop(10)
+	if got := tr.missing(); len(got) != 1 {
+		t.Fatalf("missing = %v, want одно число", got)
+	}
+	tr.arrive(10)
+	if got := tr.missing(); got != nil {
+		t.Fatalf("missing = %v, want пусто", got)
+	}
+	if len(tr.samples) != 2 {
+		t.Fatalf("задержек %d, want 2", len(tr.samples))
+	}
+}
+
+func TestTrackerWarmUpDuplicates(t *testing.T) {
+	tr := newFlightTracker(false)
+	tr.emit(3)
+	tr.emit(3)
+	tr.warmUp(3)
+
+	// холодная только одна из двух копий
+	tr.arrive(3)
+	tr.arrive(3)
+	if len(tr.samples) != 1 {
+		t.Fatalf("задержек %d, want 1", len(tr.samples))
+	}
+}
+
+func TestRepeatedSourceLatency(t *testing.T) {
+	values := make([]int64, 200)
+	for i := range values {
+		values[i] = int64(i % 7)
+	}
+	s := NewPipeline(3, WithSource(values), WithLatency(), WithGapDetection()).Run(context.Background())
+	if s.OutputCount != int64(len(values)) {
+		t.Fatalf("OutputCount = %d, want %d", s.OutputCount, len(values))
+	}
+	if len(s.MissingSeqs) != 0 {
+		t.Fatalf("MissingSeqs = %v у повторяющихся чисел", s.MissingSeqs)
+	}
+	if s.Latency.Max == 0 {
+		t.Fatalf("Latency = %+v, want непустую сводку", s.Latency)
+	}
+	if err := CheckInvariants(s); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestGapDetectionReportsDrop(t *testing.T) {
+	// воркер с ошибкой без обработчика молча теряет число 5
+	lose := WithProcessErr(func(v int64) (int64, error) {
+		if v == 5 {
+			return 0, errors.New("сбой")
+		}
+		return v, nil
+	}, nil)
+	s := NewPipeline(2, WithSource([]int64{1, 2, 3, 4, 5, 6, 7, 8}), WithGapDetection(),
+		WithWorkerOptions(lose)).Run(context.Background())
+
+	if !slices.Equal(s.MissingSeqs, []int64{5}) {
+		t.Fatalf("MissingSeqs = %v, want [5]", s.MissingSeqs)
+	}
+	if err := CheckInvariants(s); err == nil {
+		t.Fatal("CheckInvariants не заметил потерянное число")
+	}
+}
+
+func TestWarmupExcludedFromLatency(t *testing.T) {
+	const workers, warm, cold = 2, 3, 30 * time.Millisecond
+	// run запускает конвейер, в котором первые warm чисел каждого воркера
+	// обрабатываются медленно
+	run := func(opts ...Option) Stats {
+		taken := make([]int, workers)
+		opts = append(opts, WithLatency(), WithTake(100), WithPoolProcessID(func(id int, v int64) int64 {
+			taken[id]++
+			if taken[id] <= warm {
+				time.Sleep(cold)
+			}
+			return v
+		}))
+		s := NewPipeline(workers, opts...).Run(context.Background())
+		if s.OutputCount != 100 {
+			t.Fatalf("OutputCount = %d, want 100", s.OutputCount)
+		}
+		return s
+	}
+
+	// медленных чисел 6 из 100, поэтому без разогрева они видны в P95;
+	// Max не годится: пока оба воркера заняты, генератор ждёт, и это
+	// ожидание тоже входит в задержку следующего числа
+	if s := run(); s.Latency.P95 < cold {
+		t.Fatalf("без разогрева Latency.P95 = %v, want не меньше %v", s.Latency.P95, cold)
+	}
+	if s := run(WithWarmup(warm)); s.Latency.P95 >= cold || s.Latency.Max == 0 {
+		t.Fatalf("с разогревом Latency = %+v, want P95 меньше %v", s.Latency, cold)
+	}
+}
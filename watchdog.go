@@ -0,0 +1,49 @@
+package pipeline
+
+import (
+	"context"
+	"errors"
+	"time"
+)
+
+// ErrStalled — причина остановки для отменяющей функции Watchdog: если
+// передать ему func() { cancel(ErrStalled) } от context.WithCancelCause,
+// она попадёт в Stats.StopReason остановленного конвейера.
+var ErrStalled = errors.New("конвейер не продвигается")
+
+// Watchdog вызывает cancel, если счётчик progress не растёт дольше idle,
+// — например, когда конвейер встал из-за взаимной блокировки. Счётчик
+// проверяется четыре раза за idle, поэтому срабатывание запаздывает не
+// больше чем на idle/4. Watchdog блокирует вызывающего до срабатывания
+// или отмены ctx и обычно запускается в отдельной горутине. idle не
+// больше нуля отключает проверку.
+func Watchdog(ctx context.Context, cancel context.CancelFunc, progress func() int64, idle time.Duration) {
+	watchdog(ctx, cancel, progress, idle, RealClock)
+}
+
+// watchdog работает как Watchdog, но отсчитывает idle по часам clock.
+func watchdog(ctx context.Context, cancel context.CancelFunc, progress func() int64, idle time.Duration, clock Clock) {
+	if idle <= 0 {
+		return
+	}
+	ticker := clock.NewTicker(max(idle/4, time.Millisecond))
+	defer ticker.Stop()
+
+	last := progress()
+	changed := clock.Now()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case now := <-ticker.C():
+			if cur := progress(); cur != last {
+				last, changed = cur, now
+				continue
+			}
+			if now.Sub(changed) >= idle {
+				cancel()
+				return
+			}
+		}
+	}
+}
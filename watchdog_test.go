@@ -0,0 +1,49 @@
+package pipeline
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestWatchdogStalledPipeline(t *testing.T) {
+	ctx, cancel := context.WithCancelCause(context.Background())
+	defer cancel(nil)
+
+	// воркеры встают на первом же числе и ждут, пока запуск не отменят
+	block := make(chan struct{})
+	go func() {
+		<-ctx.Done()
+		close(block)
+	}()
+	var m Metrics
+	p := NewPipeline(2, WithMetrics(&m), WithPoolProcess(func(v int64) int64 {
+		<-block
+		return v
+	}))
+
+	fired := make(chan struct{})
+	go func() {
+		defer close(fired)
+		Watchdog(ctx, func() { cancel(ErrStalled) }, func() int64 { return m.Snapshot().OutputCount }, 20*time.Millisecond)
+	}()
+
+	done := make(chan Stats)
+	go func() { done <- p.Run(ctx) }()
+	select {
+	case s := <-done:
+		if !errors.Is(s.StopReason, ErrStalled) {
+			t.Fatalf("StopReason = %v, want ErrStalled", s.StopReason)
+		}
+		if err := CheckInvariants(s); err != nil {
+			t.Fatal(err)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("watchdog не остановил вставший конвейер")
+	}
+	<-fired
+	if !errors.Is(context.Cause(ctx), ErrStalled) {
+		t.Fatalf("context.Cause = %v, want ErrStalled", context.Cause(ctx))
+	}
+}